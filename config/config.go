@@ -0,0 +1,443 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// EnvPrefix is the prefix of the environment variables read by the plugin.
+// Every flag can be set with an environment variable named after it, e.g.
+// the flag -socket-path is read from SCOPE_PLUGIN_SOCKET_PATH.
+const EnvPrefix = "SCOPE_PLUGIN_"
+
+// secretMask replaces the secrets in the printed configuration.
+const secretMask = "<secret>"
+
+const (
+	// SourceAuto fails over between the Candidates, or DataSourceURL, the
+	// prometheus sidecar of the plugin deployment and CortexURL if there is
+	// none, and the services selected by DiscoverySelector.
+	SourceAuto = "auto"
+	// SourcePrometheus queries prometheus at DataSourceURL.
+	SourcePrometheus = "prometheus"
+	// SourceCortex queries cortex at CortexURL.
+	SourceCortex = "cortex"
+	// SourceScrape scrapes the volume exporter pods directly.
+	SourceScrape = "scrape"
+	// SourceFixture serves the static results of the Fixture file.
+	SourceFixture = "fixture"
+)
+
+// Duration is a time.Duration which is read from strings like "2s" in the
+// config file.
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", d.String())), nil
+}
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	return d.Set(strings.Trim(string(b), `"`))
+}
+
+// Set is part of the flag.Value interface.
+func (d *Duration) Set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// StringList is a list of strings which is read from a comma separated flag.
+type StringList []string
+
+// Set is part of the flag.Value interface.
+func (l *StringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Config holds the configuration of the plugin.
+type Config struct {
+	// SocketPath is the unix socket scope probes connect to.
+	SocketPath string `json:"socketPath"`
+	// DataSourceURL is the base URL of the prometheus compatible query API.
+	DataSourceURL string `json:"dataSourceURL"`
+	// CortexURL is the cortex agent tried after the prometheus sidecar.
+	CortexURL string `json:"cortexURL"`
+	// Candidates are the base URLs of the data sources of the auto source,
+	// in order of preference. DiscoverySelector selects the services added
+	// after them, and ProbeInterval is the interval between two health
+	// probes of the candidates.
+	Candidates        StringList `json:"candidates"`
+	DiscoverySelector string     `json:"discoverySelector"`
+	ProbeInterval     Duration   `json:"probeInterval"`
+	// Source is the kind of data source, see SourceAuto and the following
+	// constants. ScrapeSelector and ScrapePort locate the exporter pods
+	// scraped by the "scrape" source, and Fixture is the file of the
+	// "fixture" source.
+	Source         string `json:"source"`
+	ScrapeSelector string `json:"scrapeSelector"`
+	ScrapePort     int    `json:"scrapePort"`
+	Fixture        string `json:"fixture"`
+	// BearerToken or the token read from BearerTokenFile, or BasicAuthUser
+	// and BasicAuthPassword, authenticate the queries to prometheus and
+	// cortex. ServiceAccountToken sends the token of the service account of
+	// the plugin pod instead. OrgID is the cortex tenant.
+	BearerToken         string `json:"bearerToken"`
+	BearerTokenFile     string `json:"bearerTokenFile"`
+	ServiceAccountToken bool   `json:"serviceAccountToken"`
+	BasicAuthUser       string `json:"basicAuthUser"`
+	BasicAuthPassword   string `json:"basicAuthPassword"`
+	OrgID               string `json:"orgID"`
+	// CAFile verifies the certificate of the data source, and CertFile and
+	// KeyFile are the client certificate presented to it.
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	// PodNamespace restricts the pods reported with volume metrics, empty
+	// means all namespaces.
+	PodNamespace string   `json:"podNamespace"`
+	PollInterval Duration `json:"pollInterval"`
+	// PollJitter is the fraction of the poll interval added at random.
+	PollJitter float64 `json:"pollJitter"`
+	// MaxBackoff caps the poll interval after consecutive failures.
+	MaxBackoff Duration `json:"maxBackoff"`
+	// QueryTimeout bounds each query and QueryWorkers is the number of
+	// queries run concurrently.
+	QueryTimeout Duration `json:"queryTimeout"`
+	QueryWorkers int      `json:"queryWorkers"`
+	RangeWindow  Duration `json:"rangeWindow"`
+	RangeStep    Duration `json:"rangeStep"`
+	LogLevel     string   `json:"logLevel"`
+	PluginID     string   `json:"pluginID"`
+	// Catalog is the path of the query catalog, empty means the built-in one.
+	Catalog string `json:"catalog"`
+	// IdentityRules is the path of the rules matching the series to the PVs
+	// and cStor pools, empty means the built-in ones.
+	IdentityRules string `json:"identityRules"`
+	// AlertRules is the path of the alert rules evaluated on the volume
+	// metrics, and AlertWebhookURL receives their alerts.
+	AlertRules      string `json:"alertRules"`
+	AlertWebhookURL string `json:"alertWebhookURL"`
+	// MetricsAddress is the TCP address serving the metrics of the plugin
+	// itself on /metrics, empty means disabled.
+	MetricsAddress string `json:"metricsAddress"`
+	// Kubeconfig and KubeContext select the cluster when running out of
+	// cluster, see k8s.NewConfig.
+	Kubeconfig  string `json:"kubeconfig"`
+	KubeContext string `json:"kubeContext"`
+	// ExpandSize is the size in GiB added to a PVC by the expand control.
+	ExpandSize int64 `json:"expandSize"`
+}
+
+// Default returns the default configuration of the plugin.
+func Default() *Config {
+	return &Config{
+		// Put socket in sub-directory to have more control on permissions
+		SocketPath:         "/var/run/scope/plugins/openebs/openebs.sock",
+		DataSourceURL:      "",
+		CortexURL:          "http://cortex-agent-service.maya-system.svc.cluster.local:80",
+		Candidates:         nil,
+		DiscoverySelector:  "",
+		ProbeInterval:      Duration{30 * time.Second},
+		Source:             SourceAuto,
+		ScrapeSelector:     "monitoring=volume_exporter_prometheus",
+		ScrapePort:         9500,
+		Fixture:            "",
+		BearerToken:        "",
+		BearerTokenFile:    "",
+		BasicAuthUser:      "",
+		BasicAuthPassword:  "",
+		OrgID:              "",
+		CAFile:             "",
+		CertFile:           "",
+		KeyFile:            "",
+		InsecureSkipVerify: false,
+		PodNamespace:       "",
+		PollInterval:       Duration{2 * time.Second},
+		PollJitter:         0.1,
+		MaxBackoff:         Duration{2 * time.Minute},
+		QueryTimeout:       Duration{10 * time.Second},
+		QueryWorkers:       4,
+		RangeWindow:        Duration{10 * time.Minute},
+		RangeStep:          Duration{15 * time.Second},
+		LogLevel:           "info",
+		PluginID:           "openebs",
+		Catalog:            "",
+		IdentityRules:      "",
+		AlertRules:         "",
+		AlertWebhookURL:    "",
+		MetricsAddress:     "",
+		Kubeconfig:         "",
+		KubeContext:        "",
+		ExpandSize:         1,
+	}
+}
+
+// flagSet returns the flags of the plugin bound to the fields of c.
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	fs.StringVar(&c.SocketPath, "socket-path", c.SocketPath, "unix socket to listen on for scope probes")
+	fs.StringVar(&c.DataSourceURL, "data-source-url", c.DataSourceURL, "base URL of the prometheus query API, picked from the deployment if empty")
+	fs.StringVar(&c.CortexURL, "cortex-url", c.CortexURL, "base URL of the cortex agent used without a prometheus sidecar")
+	fs.Var(&c.Candidates, "candidates", "comma separated base URLs of the data sources of the auto source, in order of preference")
+	fs.StringVar(&c.DiscoverySelector, "discovery-selector", c.DiscoverySelector, "label selector of the services added to the candidates of the auto source")
+	fs.Var(&c.ProbeInterval, "probe-interval", "interval between two health probes of the candidates of the auto source")
+	fs.StringVar(&c.Source, "source", c.Source, "data source: auto, prometheus, cortex, scrape (the volume exporter pods) or fixture")
+	fs.StringVar(&c.ScrapeSelector, "scrape-selector", c.ScrapeSelector, "label selector of the exporter pods scraped by the scrape source")
+	fs.IntVar(&c.ScrapePort, "scrape-port", c.ScrapePort, "metrics port of the exporter pods scraped by the scrape source")
+	fs.StringVar(&c.Fixture, "fixture", c.Fixture, "path of the YAML or JSON results served by the fixture source")
+	fs.StringVar(&c.BearerToken, "bearer-token", c.BearerToken, "bearer token sent to the data source")
+	fs.StringVar(&c.BearerTokenFile, "bearer-token-file", c.BearerTokenFile, "file of the bearer token sent to the data source")
+	fs.BoolVar(&c.ServiceAccountToken, "service-account-token", c.ServiceAccountToken, "send the service account token of the plugin pod to the data source as bearer token")
+	fs.StringVar(&c.BasicAuthUser, "basic-auth-user", c.BasicAuthUser, "user name sent to the data source with basic authentication")
+	fs.StringVar(&c.BasicAuthPassword, "basic-auth-password", c.BasicAuthPassword, "password sent to the data source with basic authentication")
+	fs.StringVar(&c.OrgID, "org-id", c.OrgID, "cortex tenant sent in the X-Scope-OrgID header")
+	fs.StringVar(&c.CAFile, "ca-file", c.CAFile, "CA certificate verifying the data source")
+	fs.StringVar(&c.CertFile, "cert-file", c.CertFile, "client certificate presented to the data source")
+	fs.StringVar(&c.KeyFile, "key-file", c.KeyFile, "key of the client certificate")
+	fs.BoolVar(&c.InsecureSkipVerify, "insecure-skip-verify", c.InsecureSkipVerify, "do not verify the certificate of the data source")
+	fs.StringVar(&c.PodNamespace, "pod-namespace", c.PodNamespace, "namespace of the pods reported with volume metrics, all if empty")
+	fs.Var(&c.PollInterval, "poll-interval", "interval between two metrics refreshes")
+	fs.Float64Var(&c.PollJitter, "poll-jitter", c.PollJitter, "fraction of the poll interval added at random")
+	fs.Var(&c.MaxBackoff, "max-backoff", "longest poll interval after consecutive failures")
+	fs.Var(&c.QueryTimeout, "query-timeout", "timeout of a single query")
+	fs.IntVar(&c.QueryWorkers, "query-workers", c.QueryWorkers, "number of queries run concurrently")
+	fs.Var(&c.RangeWindow, "range-window", "duration of the metrics history, 0 for instant queries")
+	fs.Var(&c.RangeStep, "range-step", "resolution of the metrics history")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level (debug, info, warning, error)")
+	fs.StringVar(&c.PluginID, "plugin-id", c.PluginID, "ID of the plugin reported to scope")
+	fs.StringVar(&c.Catalog, "catalog", c.Catalog, "path of a YAML or JSON query catalog, defaults to the OpenEBS volume IO metrics")
+	fs.StringVar(&c.IdentityRules, "identity-rules", c.IdentityRules, "path of the YAML or JSON rules matching the series labels to the PVs and cStor pools")
+	fs.StringVar(&c.AlertRules, "alert-rules", c.AlertRules, "path of the YAML or JSON alert rules evaluated on the volume metrics")
+	fs.StringVar(&c.AlertWebhookURL, "alert-webhook-url", c.AlertWebhookURL, "URL receiving the alerts in the Alertmanager webhook format")
+	fs.StringVar(&c.MetricsAddress, "metrics-address", c.MetricsAddress, "TCP address serving the prometheus metrics of the plugin on /metrics, e.g. :9101, disabled if empty")
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "path of the kubeconfig, used instead of the InCluster config")
+	fs.StringVar(&c.KubeContext, "context", c.KubeContext, "kubeconfig context to use")
+	fs.Int64Var(&c.ExpandSize, "expand-size", c.ExpandSize, "size in GiB added to a PVC by the expand control")
+	return fs
+}
+
+// Load builds the configuration from the defaults, the optional config file,
+// the environment and the command line arguments, in increasing order of
+// precedence. It also reports whether -print-config was given.
+func Load(args []string) (*Config, bool, error) {
+	var configFile string
+	var printConfig bool
+
+	// First pass to find the config file and the flags set on the command line.
+	fs := Default().flagSet()
+	fs.StringVar(&configFile, "config", os.Getenv(EnvPrefix+"CONFIG"), "path of a YAML or JSON config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	cmdline := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = f.Value.String()
+	})
+
+	c := Default()
+	if configFile != "" {
+		if err := c.loadFile(configFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	fs = c.flagSet()
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for name, value := range cmdline {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, false, err
+	}
+	return c, printConfig, nil
+}
+
+// loadFile overrides the configuration with the values in the given file.
+func (c *Config) loadFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+	return nil
+}
+
+// envName returns the environment variable of the given flag.
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	if !filepath.IsAbs(c.SocketPath) {
+		return fmt.Errorf("socket path %q must be absolute", c.SocketPath)
+	}
+	if c.DataSourceURL != "" {
+		if err := validateURL("data source URL", c.DataSourceURL); err != nil {
+			return err
+		}
+	}
+	if err := validateURL("cortex URL", c.CortexURL); err != nil {
+		return err
+	}
+	switch c.Source {
+	case SourceAuto, SourcePrometheus, SourceCortex:
+	case SourceScrape:
+		if c.ScrapeSelector == "" {
+			return fmt.Errorf("scrape selector is required by the %s source", SourceScrape)
+		}
+		if c.ScrapePort <= 0 || c.ScrapePort > 65535 {
+			return fmt.Errorf("invalid scrape port %d", c.ScrapePort)
+		}
+	case SourceFixture:
+		if c.Fixture == "" {
+			return fmt.Errorf("fixture is required by the %s source", SourceFixture)
+		}
+	default:
+		return fmt.Errorf("unknown source %q, must be one of %s", c.Source, strings.Join([]string{SourceAuto, SourcePrometheus, SourceCortex, SourceScrape, SourceFixture}, ", "))
+	}
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return fmt.Errorf("bearer token and bearer token file are mutually exclusive")
+	}
+	if (c.BearerToken != "" || c.BearerTokenFile != "") && c.BasicAuthUser != "" {
+		return fmt.Errorf("bearer token and basic authentication are mutually exclusive")
+	}
+	if c.ServiceAccountToken && (c.BearerToken != "" || c.BearerTokenFile != "" || c.BasicAuthUser != "") {
+		return fmt.Errorf("service account token is mutually exclusive with the other credentials")
+	}
+	if c.BasicAuthPassword != "" && c.BasicAuthUser == "" {
+		return fmt.Errorf("basic auth password requires a basic auth user")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert file and key file must be given together")
+	}
+	if c.AlertWebhookURL != "" {
+		if err := validateURL("alert webhook URL", c.AlertWebhookURL); err != nil {
+			return err
+		}
+		if c.AlertRules == "" {
+			return fmt.Errorf("alert webhook URL requires alert rules")
+		}
+	}
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return fmt.Errorf("invalid metrics address %q: %v", c.MetricsAddress, err)
+		}
+	}
+	for _, candidate := range c.Candidates {
+		if err := validateURL("candidate", candidate); err != nil {
+			return err
+		}
+	}
+	if c.ProbeInterval.Duration <= 0 {
+		return fmt.Errorf("probe interval must be positive, got %v", c.ProbeInterval)
+	}
+	if c.PollInterval.Duration <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %v", c.PollInterval)
+	}
+	if c.PollJitter < 0 || c.PollJitter > 1 {
+		return fmt.Errorf("poll jitter must be between 0 and 1, got %v", c.PollJitter)
+	}
+	if c.MaxBackoff.Duration < c.PollInterval.Duration {
+		return fmt.Errorf("max backoff %v must not be shorter than the poll interval %v", c.MaxBackoff, c.PollInterval)
+	}
+	if c.QueryTimeout.Duration <= 0 {
+		return fmt.Errorf("query timeout must be positive, got %v", c.QueryTimeout)
+	}
+	if c.QueryWorkers <= 0 {
+		return fmt.Errorf("query workers must be positive, got %d", c.QueryWorkers)
+	}
+	if c.RangeWindow.Duration < 0 {
+		return fmt.Errorf("range window must not be negative, got %v", c.RangeWindow)
+	}
+	if c.RangeWindow.Duration > 0 && c.RangeStep.Duration < time.Second {
+		return fmt.Errorf("range step must be at least 1s, got %v", c.RangeStep)
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.ExpandSize <= 0 {
+		return fmt.Errorf("expand size must be positive, got %d", c.ExpandSize)
+	}
+	if c.PluginID == "" || strings.ContainsAny(c.PluginID, " \t/") {
+		return fmt.Errorf("invalid plugin ID %q", c.PluginID)
+	}
+	return nil
+}
+
+// validateURL checks that rawURL is an absolute http(s) URL.
+func validateURL(name, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an http(s) URL", name, rawURL)
+	}
+	return nil
+}
+
+// String returns the configuration in YAML, with the secrets masked.
+func (c *Config) String() string {
+	masked := *c
+	if masked.BearerToken != "" {
+		masked.BearerToken = secretMask
+	}
+	if masked.BasicAuthPassword != "" {
+		masked.BasicAuthPassword = secretMask
+	}
+	raw, err := yaml.Marshal(&masked)
+	if err != nil {
+		return err.Error()
+	}
+	return string(raw)
+}
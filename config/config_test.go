@@ -0,0 +1,224 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte("pollInterval: 10s\nlogLevel: debug\npluginID: fromfile\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, c *Config)
+		wantErr bool
+	}{
+		{
+			name: "when nothing is set",
+			args: nil,
+			check: func(t *testing.T, c *Config) {
+				if c.SocketPath != Default().SocketPath || c.PollInterval.Duration != 2*time.Second {
+					t.Errorf("Load() = %v, want defaults", c)
+				}
+			},
+		},
+		{
+			name: "when config file is given",
+			args: []string{"-config", configFile},
+			check: func(t *testing.T, c *Config) {
+				if c.PollInterval.Duration != 10*time.Second || c.LogLevel != "debug" || c.PluginID != "fromfile" {
+					t.Errorf("Load() = %v, want values from config file", c)
+				}
+			},
+		},
+		{
+			name: "when env overrides config file",
+			args: nil,
+			env: map[string]string{
+				"SCOPE_PLUGIN_CONFIG":        configFile,
+				"SCOPE_PLUGIN_POLL_INTERVAL": "5s",
+			},
+			check: func(t *testing.T, c *Config) {
+				if c.PollInterval.Duration != 5*time.Second || c.LogLevel != "debug" {
+					t.Errorf("Load() = %v, want poll interval from env", c)
+				}
+			},
+		},
+		{
+			name: "when flags override env",
+			args: []string{"-poll-interval", "1s", "-socket-path", "/tmp/plugin/plugin.sock"},
+			env: map[string]string{
+				"SCOPE_PLUGIN_POLL_INTERVAL": "5s",
+			},
+			check: func(t *testing.T, c *Config) {
+				if c.PollInterval.Duration != time.Second || c.SocketPath != "/tmp/plugin/plugin.sock" {
+					t.Errorf("Load() = %v, want values from flags", c)
+				}
+			},
+		},
+		{
+			name:    "when poll interval is invalid",
+			args:    []string{"-poll-interval", "0s"},
+			wantErr: true,
+		},
+		{
+			name:    "when poll jitter is invalid",
+			args:    []string{"-poll-jitter", "1.5"},
+			wantErr: true,
+		},
+		{
+			name:    "when max backoff is shorter than poll interval",
+			args:    []string{"-poll-interval", "1m", "-max-backoff", "30s"},
+			wantErr: true,
+		},
+		{
+			name:    "when query workers is invalid",
+			args:    []string{"-query-workers", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "when source is unknown",
+			args:    []string{"-source", "graphite"},
+			wantErr: true,
+		},
+		{
+			name:    "when fixture source has no fixture",
+			args:    []string{"-source", "fixture"},
+			wantErr: true,
+		},
+		{
+			name:    "when scrape port is invalid",
+			args:    []string{"-source", "scrape", "-scrape-port", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "when bearer token and basic auth are both set",
+			args:    []string{"-bearer-token", "token", "-basic-auth-user", "admin"},
+			wantErr: true,
+		},
+		{
+			name:    "when service account token and bearer token file are both set",
+			args:    []string{"-service-account-token", "-bearer-token-file", "token"},
+			wantErr: true,
+		},
+		{
+			name: "when service account token is set",
+			args: []string{"-service-account-token"},
+			check: func(t *testing.T, c *Config) {
+				if !c.ServiceAccountToken {
+					t.Errorf("Load() service account token = %v, want true", c.ServiceAccountToken)
+				}
+			},
+		},
+		{
+			name:    "when cert file is given without key file",
+			args:    []string{"-cert-file", "client.crt"},
+			wantErr: true,
+		},
+		{
+			name: "when candidates are given",
+			args: []string{"-candidates", "http://prometheus:9090, https://cortex:8443"},
+			check: func(t *testing.T, c *Config) {
+				if len(c.Candidates) != 2 || c.Candidates[1] != "https://cortex:8443" {
+					t.Errorf("Load() candidates = %v, want the two candidates", c.Candidates)
+				}
+			},
+		},
+		{
+			name:    "when a candidate is invalid",
+			args:    []string{"-candidates", "http://prometheus:9090,prometheus"},
+			wantErr: true,
+		},
+		{
+			name:    "when alert webhook URL is given without alert rules",
+			args:    []string{"-alert-webhook-url", "http://alertmanager:9093/webhook"},
+			wantErr: true,
+		},
+		{
+			name:    "when metrics address is invalid",
+			args:    []string{"-metrics-address", "9101"},
+			wantErr: true,
+		},
+		{
+			name:    "when data source URL is invalid",
+			args:    []string{"-data-source-url", "localhost:80"},
+			wantErr: true,
+		},
+		{
+			name: "when log level is invalid",
+			args: nil,
+			env: map[string]string{
+				"SCOPE_PLUGIN_LOG_LEVEL": "loud",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "when socket path is relative",
+			args:    []string{"-socket-path", "plugin.sock"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+			defer func() {
+				for k := range tt.env {
+					os.Unsetenv(k)
+				}
+			}()
+
+			got, _, err := Load(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.check != nil {
+				tt.check(t, got)
+			}
+		})
+	}
+}
+
+func TestLoadPrintConfig(t *testing.T) {
+	c, printConfig, err := Load([]string{"-print-config"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !printConfig {
+		t.Errorf("Load() printConfig = false, want true")
+	}
+	want := "pollInterval: 2s\n"
+	if got := c.String(); !strings.Contains(got, want) {
+		t.Errorf("Config.String() = %v, want to contain %v", got, want)
+	}
+}
+
+func TestConfigStringMasksSecrets(t *testing.T) {
+	c := Default()
+	c.BearerToken = "s3cr3t"
+	c.BasicAuthPassword = "passw0rd"
+	got := c.String()
+	if strings.Contains(got, "s3cr3t") || strings.Contains(got, "passw0rd") {
+		t.Errorf("Config.String() = %v, want the secrets masked", got)
+	}
+	if c.BearerToken != "s3cr3t" {
+		t.Errorf("Config.String() modified the bearer token to %q", c.BearerToken)
+	}
+}
@@ -1,26 +1,74 @@
 package k8s
 
 import (
+	"fmt"
+	"os"
+
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// NewClientSet will create a new InCluster config for k8s cluster
-func NewClientSet() kubernetes.Interface {
-	// creating in-cluster config.
-	config, err := rest.InClusterConfig()
+// inClusterConfig returns the InCluster config, it is replaced in tests.
+var inClusterConfig = rest.InClusterConfig
+
+// NewConfig returns the rest config of the k8s cluster.
+// The config is discovered in the following order:
+//   - the given kubeconfig path,
+//   - the KUBECONFIG environment variable,
+//   - the InCluster config,
+//   - the default kubeconfig at ~/.kube/config.
+//
+// If context is not empty, it overrides the current context of the kubeconfig.
+// It is an error to give a context when the InCluster config is used, since it
+// has none.
+func NewConfig(kubeconfig, context string) (*rest.Config, error) {
+	if kubeconfig == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		// creating in-cluster config.
+		config, err := inClusterConfig()
+		if err == nil {
+			if context != "" {
+				return nil, fmt.Errorf("context %q requires a kubeconfig, but the InCluster config is used", context)
+			}
+			return config, nil
+		}
+		log.Debugf("InCluster config is not available: %v", err)
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: context,
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
 	if err != nil {
-		log.Error(err)
-		return nil
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
 	}
+	log.Infof("Using kubeconfig for %s", config.Host)
+	return config, nil
+}
 
+// NewClientSet will create a new clientset for k8s cluster of the given
+// config, see NewConfig.
+func NewClientSet(config *rest.Config) (kubernetes.Interface, error) {
 	// create clientset of kubernetes.
 	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Error(err)
-		return nil
+		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+
+	return clientSet, nil
+}
+
+// NewDynamicClient will create a new dynamic client for the custom resources
+// of the k8s cluster of the given config, see NewConfig.
+func NewDynamicClient(config *rest.Config) (dynamic.Interface, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
 	}
 
-	return clientSet
+	return dynamicClient, nil
 }
@@ -0,0 +1,156 @@
+package k8s
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeconfig returns a kubeconfig whose current context is for the cluster
+// at host, with a second context "other" for https://other.
+func kubeconfig(host string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- name: main
+  cluster:
+    server: ` + host + `
+- name: other
+  cluster:
+    server: https://other
+contexts:
+- name: main
+  context:
+    cluster: main
+    user: admin
+- name: other
+  context:
+    cluster: other
+    user: admin
+current-context: main
+users:
+- name: admin
+  user:
+    token: secret
+`
+}
+
+func TestNewConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, host string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(kubeconfig(host)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	explicit := write("explicit", "https://explicit")
+	env := write("env", "https://env")
+	home := write("home", "https://home")
+
+	// The default kubeconfig and the InCluster config are replaced for the
+	// duration of the test.
+	defer func(homeFile string) { clientcmd.RecommendedHomeFile = homeFile }(clientcmd.RecommendedHomeFile)
+	defer func(load func() (*rest.Config, error)) { inClusterConfig = load }(inClusterConfig)
+	defer os.Setenv(clientcmd.RecommendedConfigPathEnvVar, os.Getenv(clientcmd.RecommendedConfigPathEnvVar))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+
+	inCluster := func() (*rest.Config, error) {
+		return &rest.Config{Host: "https://in-cluster"}, nil
+	}
+	notInCluster := func() (*rest.Config, error) {
+		return nil, errors.New("not in cluster")
+	}
+
+	tests := []struct {
+		name       string
+		kubeconfig string
+		context    string
+		envFile    string
+		homeFile   string
+		inCluster  func() (*rest.Config, error)
+		wantHost   string
+		wantErr    bool
+	}{
+		{
+			name:       "when kubeconfig is given",
+			kubeconfig: explicit,
+			envFile:    env,
+			homeFile:   home,
+			inCluster:  inCluster,
+			wantHost:   "https://explicit",
+		},
+		{
+			name:      "when KUBECONFIG is set",
+			envFile:   env,
+			homeFile:  home,
+			inCluster: inCluster,
+			wantHost:  "https://env",
+		},
+		{
+			name:      "when running in cluster",
+			homeFile:  home,
+			inCluster: inCluster,
+			wantHost:  "https://in-cluster",
+		},
+		{
+			name:      "when running out of cluster",
+			homeFile:  home,
+			inCluster: notInCluster,
+			wantHost:  "https://home",
+		},
+		{
+			name:      "when no config is available",
+			homeFile:  filepath.Join(dir, "missing"),
+			inCluster: notInCluster,
+			wantErr:   true,
+		},
+		{
+			name:       "when context is given",
+			kubeconfig: explicit,
+			context:    "other",
+			inCluster:  inCluster,
+			wantHost:   "https://other",
+		},
+		{
+			name:       "when context is unknown",
+			kubeconfig: explicit,
+			context:    "missing",
+			inCluster:  notInCluster,
+			wantErr:    true,
+		},
+		{
+			name:      "when context is given in cluster",
+			context:   "other",
+			homeFile:  home,
+			inCluster: inCluster,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(clientcmd.RecommendedConfigPathEnvVar, tt.envFile)
+			clientcmd.RecommendedHomeFile = tt.homeFile
+			inClusterConfig = tt.inCluster
+
+			got, err := NewConfig(tt.kubeconfig, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Host != tt.wantHost {
+				t.Errorf("NewConfig() host = %v, want %v", got.Host, tt.wantHost)
+			}
+		})
+	}
+}
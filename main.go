@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,16 +11,28 @@ import (
 	"path/filepath"
 	"syscall"
 
+	"github.com/openebs/scope-plugin/config"
+	"github.com/openebs/scope-plugin/k8s"
 	"github.com/openebs/scope-plugin/metrics"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
 )
 
-// setupSocket will create a unix socket at the specified socket path
+// setupSocket will create a unix socket at the specified socket path.
+// A stale socket left by a previous run is removed, but nothing else in its
+// directory, which may be shared with other plugins.
 func setupSocket(socketPath string) (net.Listener, error) {
-	os.RemoveAll(filepath.Dir(socketPath))
 	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
 		return nil, fmt.Errorf("failed to create directory %q: %v", filepath.Dir(socketPath), err)
 	}
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %q: %v", socketPath, err)
+		}
+	}
 	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on %q: %v", socketPath, err)
@@ -27,43 +41,241 @@ func setupSocket(socketPath string) (net.Listener, error) {
 	return listener, nil
 }
 
-func setupSignals(socketPath string) {
+// serveMetrics serves the metrics of the plugin on /metrics at the given
+// TCP address until ctx is done.
+func serveMetrics(ctx context.Context, address string, pvMetrics *metrics.PVMetrics) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", pvMetrics.ServeMetrics)
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Infof("Serving the plugin metrics on %s", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("Failed to serve the plugin metrics: %v", err)
+	}
+}
+
+// setupSignals will cancel the returned context on the exit signal
+func setupSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-interrupt
-		os.RemoveAll(filepath.Dir(socketPath))
-		os.Exit(0)
+		log.Info("Shutting down")
+		cancel()
 	}()
+	return ctx
+}
+
+// newSource will create the data source selected by the configuration. The
+// failed Kubernetes API calls of the source are passed to apiError, and its
+// informers run until stopCh is closed.
+func newSource(cfg *config.Config, clientSet kubernetes.Interface, apiError func(resource string, err error), stopCh <-chan struct{}) (metrics.Source, error) {
+	bearerTokenFile := cfg.BearerTokenFile
+	if cfg.ServiceAccountToken {
+		bearerTokenFile = metrics.ServiceAccountTokenFile
+	}
+	httpClient, err := metrics.NewHTTPClient(metrics.Auth{
+		BearerToken:        cfg.BearerToken,
+		BearerTokenFile:    bearerTokenFile,
+		Username:           cfg.BasicAuthUser,
+		Password:           cfg.BasicAuthPassword,
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		OrgID:              cfg.OrgID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	prometheus := func(url string) metrics.Source {
+		source := metrics.NewPrometheusSource(url)
+		source.HTTPClient = httpClient
+		return source
+	}
+	cortex := func(url string) metrics.Source {
+		source := metrics.NewCortexSource(url)
+		source.HTTPClient = httpClient
+		return source
+	}
+
+	switch cfg.Source {
+	case config.SourcePrometheus:
+		if cfg.DataSourceURL == "" {
+			return prometheus(metrics.DefaultSourceURL), nil
+		}
+		return prometheus(cfg.DataSourceURL), nil
+	case config.SourceCortex:
+		return cortex(cfg.CortexURL), nil
+	case config.SourceScrape:
+		scraper := metrics.NewScraper(clientSet)
+		scraper.Selector = cfg.ScrapeSelector
+		scraper.Port = cfg.ScrapePort
+		scraper.APIError = apiError
+		if cfg.RangeWindow.Duration > scraper.Retention {
+			scraper.Retention = cfg.RangeWindow.Duration
+		}
+		if err := scraper.StartInformer(stopCh); err != nil {
+			return nil, err
+		}
+		return scraper, nil
+	case config.SourceFixture:
+		return metrics.LoadFixture(cfg.Fixture)
+	}
+
+	failover := metrics.NewFailoverSource()
+	failover.ProbeInterval = cfg.ProbeInterval.Duration
+	switch {
+	case len(cfg.Candidates) > 0:
+		for _, candidate := range cfg.Candidates {
+			failover.Candidates = append(failover.Candidates, prometheus(candidate))
+		}
+	case cfg.DataSourceURL != "":
+		failover.Candidates = []metrics.Source{prometheus(cfg.DataSourceURL)}
+	default:
+		failover.Candidates = []metrics.Source{prometheus(metrics.DefaultSourceURL), cortex(cfg.CortexURL)}
+	}
+	if cfg.DiscoverySelector != "" {
+		discovery := &metrics.ServiceDiscovery{
+			ClientSet: clientSet,
+			Selector:  cfg.DiscoverySelector,
+			NewSource: prometheus,
+			APIError:  apiError,
+		}
+		failover.Discover = discovery.Discover
+	}
+	return failover, nil
 }
 
 func main() {
-	// Put socket in sub-directory to have more control on permissions
-	const socketPath = "/var/run/scope/plugins/openebs/openebs.sock"
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+
+	catalog := metrics.DefaultCatalog()
+	if cfg.Catalog != "" {
+		catalog, err = metrics.LoadCatalog(cfg.Catalog)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	identity := metrics.DefaultIdentityRules()
+	if cfg.IdentityRules != "" {
+		identity, err = metrics.LoadIdentityRules(cfg.IdentityRules)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var alertRules metrics.AlertRules
+	if cfg.AlertRules != "" {
+		alertRules, err = metrics.LoadAlertRules(cfg.AlertRules, catalog)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	kubeConfig, err := k8s.NewConfig(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clientSet, err := k8s.NewClientSet(kubeConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dynamicClient, err := k8s.NewDynamicClient(kubeConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Handle the exit signal
-	setupSignals(socketPath)
+	ctx := setupSignals()
+
+	pvMetrics := metrics.NewMetrics(catalog, clientSet)
+	pvMetrics.DynamicClient = dynamicClient
+	pvMetrics.Identity = identity
+	pvMetrics.AlertRules = alertRules
+	if cfg.AlertWebhookURL != "" {
+		pvMetrics.Webhook = &metrics.Webhook{
+			URL:      cfg.AlertWebhookURL,
+			Receiver: cfg.PluginID,
+		}
+	}
+	pvMetrics.ExpandSize = cfg.ExpandSize
+	pvMetrics.PluginID = cfg.PluginID
+	pvMetrics.Schedule = metrics.Schedule{
+		Interval:   cfg.PollInterval.Duration,
+		Jitter:     cfg.PollJitter,
+		MaxBackoff: cfg.MaxBackoff.Duration,
+	}
+	pvMetrics.QueryTimeout = cfg.QueryTimeout.Duration
+	pvMetrics.Workers = cfg.QueryWorkers
+	pvMetrics.PodNamespace = cfg.PodNamespace
+	pvMetrics.Range = metrics.RangeQuery{
+		Window: cfg.RangeWindow.Duration,
+		Step:   cfg.RangeStep.Duration,
+	}
+	if err := pvMetrics.StartInformers(ctx.Done()); err != nil {
+		log.Fatal(err)
+	}
+	pvMetrics.Source, err = newSource(cfg, clientSet, pvMetrics.APIError, ctx.Done())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Data source: %v", pvMetrics.Source)
+	checkCtx, cancel := context.WithTimeout(ctx, cfg.QueryTimeout.Duration)
+	if err := pvMetrics.Source.Check(checkCtx); err != nil {
+		log.Warnf("Data source is not healthy: %v", err)
+	}
+	cancel()
+	if pvMetrics.Webhook != nil {
+		go pvMetrics.Webhook.Run(ctx)
+	}
+	go pvMetrics.UpdateMetrics(ctx)
+
+	if cfg.MetricsAddress != "" {
+		go serveMetrics(ctx, cfg.MetricsAddress, pvMetrics)
+	}
+
+	// The socket is created last, so that scope only finds the plugin once
+	// it is ready to serve the reports.
+	socketPath := cfg.SocketPath
 	listener, err := setupSocket(socketPath)
 	if err != nil {
-		log.Error(err)
+		log.Fatal(err)
 	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
 
 	defer func() {
 		listener.Close()
-		os.RemoveAll(filepath.Dir(socketPath))
+		os.Remove(socketPath)
 	}()
 
-	pvMetrics := metrics.NewMetrics()
-	pvMetrics.GetPVList()
-	if count := pvMetrics.GetContainerCountInDeployment(); count < 2 {
-		metrics.URL = "http://cortex-agent-service.maya-system.svc.cluster.local:80/api/v1/query?query="
-	}
-
-	log.Infof("Data Source URL %+v", metrics.URL)
-	go pvMetrics.UpdateMetrics()
-
 	http.HandleFunc("/report", pvMetrics.Report)
-	if err := http.Serve(listener, nil); err != nil {
+	http.HandleFunc("/control", pvMetrics.Control)
+	if err := http.Serve(listener, nil); err != nil && ctx.Err() == nil {
 		log.Errorf("error: %v", err)
 	}
 }
@@ -0,0 +1,449 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// alertsPrefix is the prefix of the latest keys shown in the alert table
+	// of the PV nodes.
+	alertsPrefix = "openebs_alert_"
+	// alertsTable is the ID of the alert table of the PV nodes.
+	alertsTable = "openebs_alerts"
+	// alertsFiring is the latest key of the names of the firing alerts.
+	alertsFiring = "openebs_alerts_firing"
+
+	// AlertFiring is the status of an alert whose condition holds.
+	AlertFiring = "firing"
+	// AlertResolved is the status of an alert whose condition no longer holds.
+	AlertResolved = "resolved"
+)
+
+// AlertRule fires for a volume when its Metric compares with Op to the
+// Threshold for at least the For duration, e.g.
+//
+//   - name: WriteLatencyHigh
+//     metric: writeLatency
+//     op: ">"
+//     threshold: 50
+//     for: 5m
+//
+// The threshold is in the unit of the metric, milliseconds for the latencies.
+type AlertRule struct {
+	Name string `json:"name"`
+	// Metric is the ID of a volume query of the catalog.
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for,omitempty"`
+	Severity  string  `json:"severity,omitempty"`
+	Summary   string  `json:"summary,omitempty"`
+
+	duration time.Duration
+}
+
+// AlertRules are evaluated on the volume metrics after each refresh.
+type AlertRules []AlertRule
+
+// AlertState is the state of an alert rule for a volume.
+type AlertState struct {
+	Status string
+	Value  float64
+	// Pending is when the condition started to hold, zero if it does not.
+	Pending time.Time
+	// Since is when the alert fired or was resolved.
+	Since time.Time
+}
+
+// Alert is an alert in the Alertmanager webhook format.
+type Alert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// LoadAlertRules reads YAML or JSON alert rules from the given file and
+// checks them against the catalog.
+func LoadAlertRules(path string, catalog Catalog) (AlertRules, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AlertRules
+	if err := yaml.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules %q: %v", path, err)
+	}
+
+	if err := rules.Validate(catalog); err != nil {
+		return nil, fmt.Errorf("invalid alert rules %q: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Validate checks that the rules are usable with the volume queries of the
+// catalog and parses their durations.
+func (r AlertRules) Validate(catalog Catalog) error {
+	volumes := catalog.topology(TopologyVolume)
+	names := make(map[string]bool)
+	for i := range r {
+		rule := &r[i]
+		if rule.Name == "" || rule.Metric == "" {
+			return fmt.Errorf("rule %d: name and metric are required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %d: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if volumes.index(rule.Metric) < 0 {
+			return fmt.Errorf("rule %d: unknown volume metric %q", i, rule.Metric)
+		}
+		if _, err := compare(rule.Op, 0, 0); err != nil {
+			return fmt.Errorf("rule %d: %v", i, err)
+		}
+		rule.duration = 0
+		if rule.For != "" {
+			duration, err := time.ParseDuration(rule.For)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", i, err)
+			}
+			rule.duration = duration
+		}
+	}
+	return nil
+}
+
+// compare reports whether value compares with op to threshold.
+func compare(op string, value, threshold float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// evaluate returns the states of the rules for each volume at now, keyed by
+// PV name and rule name, and the alerts which fired or were resolved. Only
+// the pending and firing states are kept: the alerts of the deleted volumes
+// are resolved, and the resolved states are dropped once their alert is
+// returned.
+func (r AlertRules) evaluate(catalog Catalog, s *Snapshot, now time.Time) (map[string]map[string]AlertState, []Alert) {
+	states := make(map[string]map[string]AlertState)
+	var alerts []Alert
+	volumes := make(map[string]bool)
+	for pvName := range s.PVList {
+		volumes[pvName] = true
+	}
+	for pvName := range s.Alerts {
+		volumes[pvName] = true
+	}
+
+	for pvName := range volumes {
+		_, exists := s.PVList[pvName]
+		for _, rule := range r {
+			state := s.Alerts[pvName][rule.Name]
+			value := math.NaN()
+			if index := catalog.index(rule.Metric); index >= 0 && exists {
+				if v, ok := s.Data[catalog[index].Name][pvName]; ok {
+					value = v
+				}
+			}
+			holds, _ := compare(rule.Op, value, rule.Threshold)
+			holds = holds && !math.IsNaN(value)
+
+			switch {
+			case holds:
+				if state.Pending.IsZero() {
+					state.Pending = now
+				}
+				state.Value = value
+				if state.Status != AlertFiring && now.Sub(state.Pending) >= rule.duration {
+					state.Status = AlertFiring
+					state.Since = now
+					alerts = append(alerts, rule.alert(pvName, state))
+				}
+			case state.Status == AlertFiring:
+				state.Status = AlertResolved
+				state.Since = now
+				alerts = append(alerts, rule.alert(pvName, state))
+				state.Pending = time.Time{}
+			default:
+				state.Pending = time.Time{}
+			}
+
+			if state.Status != AlertFiring && state.Pending.IsZero() {
+				continue
+			}
+			if states[pvName] == nil {
+				states[pvName] = make(map[string]AlertState)
+			}
+			states[pvName][rule.Name] = state
+		}
+	}
+	return states, alerts
+}
+
+// alert returns the webhook alert of the rule for a volume in the given state.
+func (rule AlertRule) alert(pvName string, state AlertState) Alert {
+	summary := rule.Summary
+	if summary == "" {
+		summary = fmt.Sprintf("%s of %s is %s %s %s", rule.Metric, pvName, formatValue(state.Value), rule.Op, formatValue(rule.Threshold))
+	}
+	alert := Alert{
+		Status: state.Status,
+		Labels: map[string]string{
+			"alertname":        rule.Name,
+			"persistentvolume": pvName,
+			"metric":           rule.Metric,
+		},
+		Annotations: map[string]string{
+			"summary": summary,
+			"value":   formatValue(state.Value),
+		},
+		StartsAt: state.Pending,
+	}
+	if rule.Severity != "" {
+		alert.Labels["severity"] = rule.Severity
+	}
+	if state.Status == AlertResolved {
+		alert.EndsAt = state.Since
+	}
+	return alert
+}
+
+// evaluateAlerts evaluates the alert rules on the current snapshot and queues
+// the alerts which fired or were resolved to the webhook, if any.
+func (p *PVMetrics) evaluateAlerts(now time.Time) {
+	if len(p.AlertRules) == 0 {
+		return
+	}
+
+	var alerts []Alert
+	p.update(func(s *Snapshot) {
+		s.Alerts, alerts = p.AlertRules.evaluate(p.catalog(), s, now)
+	})
+	for _, alert := range alerts {
+		log.Infof("Alert %s %s for %s", alert.Labels["alertname"], alert.Status, alert.Labels["persistentvolume"])
+	}
+	if p.Webhook != nil && len(alerts) > 0 {
+		p.Webhook.enqueue(alerts, p.queryTimeout())
+	}
+}
+
+// webhookQueueSize is the number of alert posts waiting to be sent, beyond
+// which further alerts are dropped.
+const webhookQueueSize = 64
+
+// Webhook posts the alerts to a URL in the format of the Alertmanager
+// webhook receivers.
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client
+	// Receiver is reported as the receiver of the alerts.
+	Receiver string
+
+	once  sync.Once
+	queue chan webhookPost
+}
+
+// Run sends the queued alerts one post at a time until ctx is done.
+func (w *Webhook) Run(ctx context.Context) {
+	queue := w.postQueue()
+	for {
+		select {
+		case post := <-queue:
+			postCtx, cancel := context.WithTimeout(ctx, post.timeout)
+			if err := w.Send(postCtx, post.alerts); err != nil {
+				log.Errorf("Failed to send %d alerts: %v", len(post.alerts), err)
+			}
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// postQueue returns the queue of the posts, created on first use.
+func (w *Webhook) postQueue() chan webhookPost {
+	w.once.Do(func() {
+		w.queue = make(chan webhookPost, webhookQueueSize)
+	})
+	return w.queue
+}
+
+// webhookPost is a post of alerts waiting in the queue of the webhook.
+type webhookPost struct {
+	alerts  []Alert
+	timeout time.Duration
+}
+
+// enqueue queues the alerts, which are sent in order by Run, each post
+// bounded by timeout. The alerts are dropped if the queue is full, e.g. when
+// the webhook is down for a long time.
+func (w *Webhook) enqueue(alerts []Alert, timeout time.Duration) {
+	select {
+	case w.postQueue() <- webhookPost{alerts: alerts, timeout: timeout}:
+	default:
+		log.Errorf("Dropped %d alerts, the webhook queue is full", len(alerts))
+	}
+}
+
+// webhookMessage is the payload of the Alertmanager webhook receivers.
+type webhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Send posts the alerts. The message is firing if any of them is.
+func (w *Webhook) Send(ctx context.Context, alerts []Alert) error {
+	message := webhookMessage{
+		Version:           "4",
+		GroupKey:          "{}:{}",
+		Status:            AlertResolved,
+		Receiver:          w.Receiver,
+		GroupLabels:       map[string]string{},
+		CommonLabels:      commonLabels(alerts),
+		CommonAnnotations: map[string]string{},
+		Alerts:            alerts,
+	}
+	for _, alert := range alerts {
+		if alert.Status == AlertFiring {
+			message.Status = AlertFiring
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	client := w.HTTPClient
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// commonLabels returns the labels shared by all the alerts.
+func commonLabels(alerts []Alert) map[string]string {
+	common := make(map[string]string)
+	for i, alert := range alerts {
+		for name, value := range alert.Labels {
+			if i == 0 {
+				common[name] = value
+			}
+		}
+		for name, value := range common {
+			if alert.Labels[name] != value {
+				delete(common, name)
+			}
+		}
+	}
+	return common
+}
+
+// alertsLatest returns the latest entries of the alert table of a volume
+// and the names of its firing alerts.
+func alertsLatest(states map[string]AlertState) map[string]latestEntry {
+	if len(states) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	latest := make(map[string]latestEntry)
+	var firing []string
+	for name, state := range states {
+		status := state.Status
+		if status == "" {
+			status = "pending"
+		}
+		since := state.Since
+		if since.IsZero() {
+			since = state.Pending
+		}
+		if state.Status == AlertFiring {
+			firing = append(firing, name)
+		}
+		columns := map[string]string{
+			"name":   name,
+			"status": status,
+			"value":  formatValue(state.Value),
+			"since":  since.Format(time.RFC3339),
+		}
+		for column, value := range columns {
+			latest[alertsPrefix+name+"___"+column] = latestEntry{
+				Timestamp: now,
+				Value:     value,
+			}
+		}
+	}
+	if len(firing) > 0 {
+		sort.Strings(firing)
+		latest[alertsFiring] = latestEntry{
+			Timestamp: now,
+			Value:     strings.Join(firing, ", "),
+		}
+	}
+	return latest
+}
+
+// alertsTableTemplate returns the alert table of the PV node panel.
+func alertsTableTemplate() tableTemplate {
+	return tableTemplate{
+		ID:     alertsTable,
+		Label:  "Alerts",
+		Prefix: alertsPrefix,
+		Type:   multiColumnTableType,
+		Columns: []column{
+			{ID: "name", Label: "Name"},
+			{ID: "status", Label: "Status"},
+			{ID: "value", Label: "Value"},
+			{ID: "since", Label: "Since"},
+		},
+	}
+}
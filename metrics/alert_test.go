@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadAlertRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "when rules are valid",
+			content: "- name: LatencyHigh\n  metric: writeLatency\n  op: '>'\n  threshold: 50\n  for: 5m\n",
+		},
+		{
+			name:    "when the metric is unknown",
+			content: "- name: LatencyHigh\n  metric: latency\n  op: '>'\n  threshold: 50\n",
+			wantErr: true,
+		},
+		{
+			name:    "when the metric is a pool metric",
+			content: "- name: PoolLatencyHigh\n  metric: poolWriteLatency\n  op: '>'\n  threshold: 50\n",
+			wantErr: true,
+		},
+		{
+			name:    "when the operator is unknown",
+			content: "- name: LatencyHigh\n  metric: writeLatency\n  op: '=>'\n  threshold: 50\n",
+			wantErr: true,
+		},
+		{
+			name:    "when the duration is invalid",
+			content: "- name: LatencyHigh\n  metric: writeLatency\n  op: '>'\n  threshold: 50\n  for: 5\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, filepath.Base(t.Name())+".yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadAlertRules(path, DefaultCatalog()); (err != nil) != tt.wantErr {
+				t.Errorf("LoadAlertRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPVMetrics_evaluateAlerts(t *testing.T) {
+	rules := AlertRules{
+		{Name: "LatencyHigh", Metric: "writeLatency", Op: ">", Threshold: 50, For: "5m"},
+		{Name: "AlmostFull", Metric: "usedPercent", Op: ">=", Threshold: 85, Severity: "critical"},
+	}
+	if err := rules.Validate(DefaultCatalog()); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	p := &PVMetrics{
+		AlertRules: rules,
+	}
+	setData := func(latency, used float64) {
+		p.update(func(s *Snapshot) {
+			s.PVList = map[string]string{"pvc-1": "uid-1"}
+			s.Data = map[string]map[string]float64{
+				"latencyWriteQuery": {"pvc-1": latency},
+				"usedPercentQuery":  {"pvc-1": used},
+			}
+		})
+	}
+	state := func(rule string) AlertState {
+		return p.Snapshot().Alerts["pvc-1"][rule]
+	}
+
+	start := time.Now()
+	setData(80, 90)
+	p.evaluateAlerts(start)
+	if got := state("LatencyHigh"); got.Status != "" || !got.Pending.Equal(start) {
+		t.Errorf("LatencyHigh = %+v, want pending", got)
+	}
+	if got := state("AlmostFull"); got.Status != AlertFiring || got.Value != 90 {
+		t.Errorf("AlmostFull = %+v, want firing without delay", got)
+	}
+
+	p.evaluateAlerts(start.Add(5 * time.Minute))
+	if got := state("LatencyHigh"); got.Status != AlertFiring {
+		t.Errorf("LatencyHigh = %+v, want firing after 5m", got)
+	}
+	latest := alertsLatest(p.Snapshot().Alerts["pvc-1"])
+	if got := latest[alertsFiring].Value; got != "AlmostFull, LatencyHigh" {
+		t.Errorf("alertsLatest() firing = %q, want both alerts", got)
+	}
+	if got := latest[alertsPrefix+"LatencyHigh___status"].Value; got != AlertFiring {
+		t.Errorf("alertsLatest() LatencyHigh status = %q, want firing", got)
+	}
+
+	// The resolved alerts are dropped.
+	setData(10, 90)
+	p.evaluateAlerts(start.Add(6 * time.Minute))
+	if got, ok := p.Snapshot().Alerts["pvc-1"]["LatencyHigh"]; ok {
+		t.Errorf("LatencyHigh = %+v, want no state once resolved", got)
+	}
+	if got := state("AlmostFull"); got.Status != AlertFiring {
+		t.Errorf("AlmostFull = %+v, want still firing", got)
+	}
+
+	// The alerts of a deleted volume are resolved, then dropped.
+	p.update(func(s *Snapshot) {
+		s.PVList = nil
+	})
+	p.evaluateAlerts(start.Add(7 * time.Minute))
+	if got := p.Snapshot().Alerts; len(got) != 0 {
+		t.Errorf("Alerts = %+v, want none after the volume is deleted", got)
+	}
+}
+
+func TestWebhook_Send(t *testing.T) {
+	var got webhookMessage
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer testServer.Close()
+
+	rule := AlertRule{Name: "AlmostFull", Metric: "usedPercent", Op: ">", Threshold: 85, Severity: "critical"}
+	now := time.Now()
+	alerts := []Alert{
+		rule.alert("pvc-1", AlertState{Status: AlertFiring, Value: 90, Pending: now, Since: now}),
+		rule.alert("pvc-2", AlertState{Status: AlertResolved, Value: 70, Pending: now, Since: now}),
+	}
+	w := &Webhook{URL: testServer.URL, Receiver: "openebs"}
+	if err := w.Send(context.Background(), alerts); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got.Version != "4" || got.Status != AlertFiring || got.Receiver != "openebs" || len(got.Alerts) != 2 {
+		t.Errorf("Send() posted %+v, want a firing message with 2 alerts", got)
+	}
+	if got.CommonLabels["alertname"] != "AlmostFull" || got.CommonLabels["persistentvolume"] != "" {
+		t.Errorf("Send() common labels = %v, want the alert name only", got.CommonLabels)
+	}
+	if summary := got.Alerts[0].Annotations["summary"]; summary != "usedPercent of pvc-1 is 90 > 85" {
+		t.Errorf("Send() summary = %q", summary)
+	}
+	if got.Alerts[1].EndsAt.IsZero() || !got.Alerts[0].EndsAt.IsZero() {
+		t.Errorf("Send() alerts = %+v, want only the resolved alert to end", got.Alerts)
+	}
+
+	notFoundServer := httptest.NewServer(http.NotFoundHandler())
+	defer notFoundServer.Close()
+	w.URL = notFoundServer.URL
+	if err := w.Send(context.Background(), alerts); err == nil {
+		t.Error("Send() error = nil, want an error on 404")
+	}
+}
+
+func TestWebhook_enqueue(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	inflight, maxInflight := 0, 0
+	done := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message webhookMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		name := message.CommonLabels["alertname"]
+		mu.Lock()
+		got = append(got, name)
+		if len(got) == 4 {
+			close(done)
+		}
+		mu.Unlock()
+
+		if name == "Slow" {
+			// The post times out, and the next ones are still sent.
+			<-r.Context().Done()
+			return
+		}
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+	}))
+	defer testServer.Close()
+
+	w := &Webhook{URL: testServer.URL}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+	now := time.Now()
+	for _, name := range []string{"First", "Slow", "Second", "Third"} {
+		rule := AlertRule{Name: name, Metric: "usedPercent", Op: ">", Threshold: 85}
+		w.enqueue([]Alert{rule.alert("pvc-1", AlertState{Status: AlertFiring, Value: 90, Pending: now, Since: now})}, 100*time.Millisecond)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueue() did not send the alerts")
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return once the context is done")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"First", "Slow", "Second", "Third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("enqueue() posted %v, want %v", got, want)
+	}
+	if maxInflight != 1 {
+		t.Errorf("enqueue() sent %d posts at once, want 1", maxInflight)
+	}
+}
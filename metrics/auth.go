@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServiceAccountTokenFile is the token of the service account of the plugin
+// pod, which can be sent as bearer token.
+const ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Auth holds the credentials and the TLS settings of the connections to the
+// data source.
+type Auth struct {
+	// BearerToken is sent in the Authorization header. BearerTokenFile is
+	// read on each request instead, so that rotated tokens are picked up.
+	BearerToken     string
+	BearerTokenFile string
+	// Username and Password are sent with basic authentication.
+	Username string
+	Password string
+	// CAFile verifies the certificate of the server, and CertFile and
+	// KeyFile are the client certificate.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	// OrgID is sent in the X-Scope-OrgID header, which selects the tenant
+	// of cortex.
+	OrgID string
+}
+
+// NewHTTPClient returns a client which authenticates its requests with the
+// given credentials.
+func NewHTTPClient(auth Auth) (*http.Client, error) {
+	if auth.BearerToken != "" && auth.BearerTokenFile != "" {
+		return nil, errors.New("bearer token and bearer token file are mutually exclusive")
+	}
+	if (auth.BearerToken != "" || auth.BearerTokenFile != "") && auth.Username != "" {
+		return nil, errors.New("bearer token and basic authentication are mutually exclusive")
+	}
+
+	tlsConfig, err := auth.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{
+		Transport: &authTransport{
+			auth: auth,
+			next: transport,
+		},
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration of the client, nil for the
+// defaults.
+func (a Auth) tlsConfig() (*tls.Config, error) {
+	if a.CAFile == "" && a.CertFile == "" && a.KeyFile == "" && !a.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: a.InsecureSkipVerify,
+	}
+	if a.CAFile != "" {
+		ca, err := ioutil.ReadFile(a.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in CA file %q", a.CAFile)
+		}
+	}
+	if a.CertFile != "" || a.KeyFile != "" {
+		if a.CertFile == "" || a.KeyFile == "" {
+			return nil, errors.New("client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// authTransport adds the credentials and the tenant to the requests.
+type authTransport struct {
+	auth Auth
+	next http.RoundTripper
+}
+
+// RoundTrip is part of the http.RoundTripper interface.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+2)
+	for key, values := range req.Header {
+		r.Header[key] = values
+	}
+
+	token := t.auth.BearerToken
+	if t.auth.BearerTokenFile != "" {
+		raw, err := ioutil.ReadFile(t.auth.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token: %v", err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	switch {
+	case token != "":
+		r.Header.Set("Authorization", "Bearer "+token)
+	case t.auth.Username != "":
+		r.SetBasicAuth(t.auth.Username, t.auth.Password)
+	}
+	if t.auth.OrgID != "" {
+		r.Header.Set("X-Scope-OrgID", t.auth.OrgID)
+	}
+	return t.next.RoundTrip(r)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	var gotAuth, gotOrgID string
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotOrgID = r.Header.Get("Authorization"), r.Header.Get("X-Scope-OrgID")
+		w.Write([]byte(`{"status":"success","data":{"version":"2.13.1"}}`))
+	}))
+	defer testServer.Close()
+
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token-from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		auth       Auth
+		wantAuth   string
+		wantOrgID  string
+		wantErr    bool
+		wantNewErr bool
+	}{
+		{
+			name:    "when the server certificate is not trusted",
+			auth:    Auth{},
+			wantErr: true,
+		},
+		{
+			name:     "when certificate verification is skipped",
+			auth:     Auth{InsecureSkipVerify: true},
+			wantAuth: "",
+		},
+		{
+			name:      "when bearer token and tenant are given",
+			auth:      Auth{CAFile: caFile, BearerToken: "token", OrgID: "tenant-1"},
+			wantAuth:  "Bearer token",
+			wantOrgID: "tenant-1",
+		},
+		{
+			name:     "when bearer token file is given",
+			auth:     Auth{CAFile: caFile, BearerTokenFile: tokenFile},
+			wantAuth: "Bearer token-from-file",
+		},
+		{
+			name:     "when basic auth is given",
+			auth:     Auth{CAFile: caFile, Username: "admin", Password: "secret"},
+			wantAuth: "Basic YWRtaW46c2VjcmV0",
+		},
+		{
+			name:    "when bearer token file is missing",
+			auth:    Auth{CAFile: caFile, BearerTokenFile: filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+		{
+			name:       "when client certificate has no key",
+			auth:       Auth{CertFile: caFile},
+			wantNewErr: true,
+		},
+		{
+			name:       "when bearer token and basic auth are both given",
+			auth:       Auth{BearerToken: "token", Username: "admin"},
+			wantNewErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth, gotOrgID = "", ""
+			client, err := NewHTTPClient(tt.auth)
+			if (err != nil) != tt.wantNewErr {
+				t.Fatalf("NewHTTPClient() error = %v, wantErr %v", err, tt.wantNewErr)
+			}
+			if err != nil {
+				return
+			}
+
+			s := NewPrometheusSource(testServer.URL)
+			s.HTTPClient = client
+			err = s.Check(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gotAuth != tt.wantAuth || gotOrgID != tt.wantOrgID {
+				t.Errorf("Check() sent Authorization %q and X-Scope-OrgID %q, want %q and %q", gotAuth, gotOrgID, tt.wantAuth, tt.wantOrgID)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// AggregateSum sums the metric across volumes.
+	AggregateSum = "sum"
+	// AggregateAverage averages the metric across volumes.
+	AggregateAverage = "average"
+	// TopologyVolume reports the query on the volume nodes, keyed by PV name.
+	TopologyVolume = "volume"
+	// TopologyPool reports the query in the pool table of the host nodes,
+	// keyed by pool name.
+	TopologyPool = "pool"
+)
+
+// Query describes a metric reported by the plugin and the PromQL used to fetch it.
+type Query struct {
+	// ID is the scope metric ID.
+	ID string `json:"id"`
+	// Name is the key of the query in PVMetrics.Queries and PVMetrics.Data.
+	Name   string `json:"name"`
+	Label  string `json:"label,omitempty"`
+	PromQL string `json:"query"`
+	// Fallback is the PromQL of the values of the volumes missing from the
+	// results of the query, e.g. the same metric from another exporter.
+	Fallback string  `json:"fallback,omitempty"`
+	Format   string  `json:"format,omitempty"`
+	Priority float64 `json:"priority,omitempty"`
+	// Min and Max fix the range of the metric instead of computing it from the samples.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Round reports the values rounded to whole numbers.
+	Round bool `json:"round,omitempty"`
+	// Aggregate is used to combine the metric of several volumes on pod and
+	// StorageClass nodes. It is either "sum" (default) or "average".
+	Aggregate string `json:"aggregate,omitempty"`
+	// WeightedBy is the ID of the metric used to weight the average.
+	WeightedBy string `json:"weightedBy,omitempty"`
+	// Topology is the topology reporting the metric, either "volume"
+	// (default) or "pool".
+	Topology string `json:"topology,omitempty"`
+}
+
+// Catalog is the ordered list of queries reported by the plugin.
+type Catalog []Query
+
+// DefaultCatalog returns the catalog of the OpenEBS volume and cStor pool
+// IO metrics and of the volume capacity. The capacity is read from the
+// kubelet volume stats, or else from the OpenEBS exporter which reports it
+// in GiB.
+func DefaultCatalog() Catalog {
+	percentMin, percentMax := 0.0, 100.0
+	return Catalog{
+		{
+			ID:       "readIops",
+			Name:     "iopsReadQuery",
+			Label:    "Iops(R)",
+			PromQL:   "irate(openebs_reads[5m])",
+			Priority: 0.1,
+			Round:    true,
+		},
+		{
+			ID:       "writeIops",
+			Name:     "iopsWriteQuery",
+			Label:    "Iops(W)",
+			PromQL:   "irate(openebs_writes[5m])",
+			Priority: 0.2,
+			Round:    true,
+		},
+		{
+			ID:         "readLatency",
+			Name:       "latencyReadQuery",
+			Label:      "Latency(R)",
+			PromQL:     "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000",
+			Format:     "millisecond",
+			Priority:   0.3,
+			Aggregate:  AggregateAverage,
+			WeightedBy: "readIops",
+		},
+		{
+			ID:         "writeLatency",
+			Name:       "latencyWriteQuery",
+			Label:      "Latency(W)",
+			PromQL:     "((irate(openebs_write_time[5m]))/(irate(openebs_writes[5m])))/1000000",
+			Format:     "millisecond",
+			Priority:   0.4,
+			Aggregate:  AggregateAverage,
+			WeightedBy: "writeIops",
+		},
+		{
+			ID:       "readThroughput",
+			Name:     "throughputReadQuery",
+			Label:    "Throughput(R)",
+			PromQL:   "irate(openebs_read_block_count[5m])/(2048)",
+			Format:   "bytes",
+			Priority: 0.5,
+		},
+		{
+			ID:       "writeThroughput",
+			Name:     "throughputWriteQuery",
+			Label:    "Throughput(W)",
+			PromQL:   "irate(openebs_write_block_count[5m])/(2048)",
+			Format:   "bytes",
+			Priority: 0.6,
+		},
+		{
+			ID:       "capacity",
+			Name:     "capacityQuery",
+			Label:    "Capacity",
+			PromQL:   "kubelet_volume_stats_capacity_bytes",
+			Fallback: "openebs_size_of_volume*1073741824",
+			Format:   "filesize",
+			Priority: 0.7,
+		},
+		{
+			ID:       "used",
+			Name:     "usedQuery",
+			Label:    "Used",
+			PromQL:   "kubelet_volume_stats_used_bytes",
+			Fallback: "openebs_actual_used*1073741824",
+			Format:   "filesize",
+			Priority: 0.8,
+		},
+		{
+			ID:         "usedPercent",
+			Name:       "usedPercentQuery",
+			Label:      "Used %",
+			PromQL:     "100*kubelet_volume_stats_used_bytes/kubelet_volume_stats_capacity_bytes",
+			Fallback:   "100*openebs_actual_used/openebs_size_of_volume",
+			Format:     "percent",
+			Priority:   0.9,
+			Min:        &percentMin,
+			Max:        &percentMax,
+			Aggregate:  AggregateAverage,
+			WeightedBy: "capacity",
+		},
+		{
+			ID:       "inodesUsed",
+			Name:     "inodesUsedQuery",
+			Label:    "Inodes used",
+			PromQL:   "kubelet_volume_stats_inodes_used",
+			Priority: 1.0,
+			Round:    true,
+		},
+		{
+			ID:        "inodesUsedPercent",
+			Name:      "inodesUsedPercentQuery",
+			Label:     "Inodes used %",
+			PromQL:    "100*kubelet_volume_stats_inodes_used/kubelet_volume_stats_inodes",
+			Format:    "percent",
+			Priority:  1.1,
+			Min:       &percentMin,
+			Max:       &percentMax,
+			Aggregate: AggregateAverage,
+		},
+		// The cStor pool exporter reports the IO counters of each volume
+		// replica of the pool, which are summed by pool.
+		{
+			ID:       "poolReadIops",
+			Name:     "poolIopsReadQuery",
+			Label:    "Iops(R)",
+			PromQL:   "sum by (cstor_pool) (irate(openebs_total_read_count[5m]))",
+			Priority: 0.1,
+			Round:    true,
+			Topology: TopologyPool,
+		},
+		{
+			ID:       "poolWriteIops",
+			Name:     "poolIopsWriteQuery",
+			Label:    "Iops(W)",
+			PromQL:   "sum by (cstor_pool) (irate(openebs_total_write_count[5m]))",
+			Priority: 0.2,
+			Round:    true,
+			Topology: TopologyPool,
+		},
+		{
+			ID:       "poolReadLatency",
+			Name:     "poolLatencyReadQuery",
+			Label:    "Latency(R)",
+			PromQL:   "(sum by (cstor_pool) (irate(openebs_total_read_time[5m])))/(sum by (cstor_pool) (irate(openebs_total_read_count[5m])))/1000000",
+			Format:   "millisecond",
+			Priority: 0.3,
+			Topology: TopologyPool,
+		},
+		{
+			ID:       "poolWriteLatency",
+			Name:     "poolLatencyWriteQuery",
+			Label:    "Latency(W)",
+			PromQL:   "(sum by (cstor_pool) (irate(openebs_total_write_time[5m])))/(sum by (cstor_pool) (irate(openebs_total_write_count[5m])))/1000000",
+			Format:   "millisecond",
+			Priority: 0.4,
+			Topology: TopologyPool,
+		},
+	}
+}
+
+// LoadCatalog reads a YAML or JSON query catalog from the given file.
+func LoadCatalog(path string) (Catalog, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(raw, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse query catalog %q: %v", path, err)
+	}
+
+	if err := catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query catalog %q: %v", path, err)
+	}
+	return catalog, nil
+}
+
+// Validate checks that the catalog is usable by the plugin.
+func (c Catalog) Validate() error {
+	if len(c) == 0 {
+		return fmt.Errorf("no queries defined")
+	}
+
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for i, query := range c {
+		if query.ID == "" || query.Name == "" || query.PromQL == "" {
+			return fmt.Errorf("query %d: id, name and query are required", i)
+		}
+		if ids[query.ID] {
+			return fmt.Errorf("query %d: duplicate id %q", i, query.ID)
+		}
+		if names[query.Name] {
+			return fmt.Errorf("query %d: duplicate name %q", i, query.Name)
+		}
+		if query.Aggregate != "" && query.Aggregate != AggregateSum && query.Aggregate != AggregateAverage {
+			return fmt.Errorf("query %d: unknown aggregate %q", i, query.Aggregate)
+		}
+		if query.Topology != "" && query.Topology != TopologyVolume && query.Topology != TopologyPool {
+			return fmt.Errorf("query %d: unknown topology %q", i, query.Topology)
+		}
+		ids[query.ID] = true
+		names[query.Name] = true
+	}
+
+	for i, query := range c {
+		if query.WeightedBy == "" {
+			continue
+		}
+		if !ids[query.WeightedBy] {
+			return fmt.Errorf("query %d: unknown weightedBy metric %q", i, query.WeightedBy)
+		}
+		if c.topology(query.Topology).index(query.WeightedBy) < 0 {
+			return fmt.Errorf("query %d: weightedBy metric %q is not in the same topology", i, query.WeightedBy)
+		}
+	}
+	return nil
+}
+
+// Queries returns the PromQL of each query keyed by the query name.
+func (c Catalog) Queries() map[string]string {
+	queries := make(map[string]string)
+	for _, query := range c {
+		queries[query.Name] = query.PromQL
+	}
+	return queries
+}
+
+// fallback returns the fallback PromQL of the query with the given name.
+func (c Catalog) fallback(name string) string {
+	for _, query := range c {
+		if query.Name == name {
+			return query.Fallback
+		}
+	}
+	return ""
+}
+
+// has returns whether the catalog has a query with the given name.
+func (c Catalog) has(name string) bool {
+	for _, query := range c {
+		if query.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// index returns the position of the query with the given metric ID, or -1.
+func (c Catalog) index(id string) int {
+	for i, query := range c {
+		if query.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// topology returns the queries of the given topology, in catalog order.
+func (c Catalog) topology(topology string) Catalog {
+	if topology == "" {
+		topology = TopologyVolume
+	}
+	var queries Catalog
+	for _, query := range c {
+		queryTopology := query.Topology
+		if queryTopology == "" {
+			queryTopology = TopologyVolume
+		}
+		if queryTopology == topology {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeCatalog(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCatalog(t *testing.T) {
+	max := float64(64)
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    Catalog
+		wantErr bool
+	}{
+		{
+			name: "when catalog is YAML",
+			file: "catalog.yaml",
+			content: `
+- id: queueDepth
+  name: queueDepthQuery
+  label: Queue Depth
+  query: openebs_queue_depth
+  priority: 0.7
+  max: 64
+  round: true
+  aggregate: average
+`,
+			want: Catalog{
+				{
+					ID:        "queueDepth",
+					Name:      "queueDepthQuery",
+					Label:     "Queue Depth",
+					PromQL:    "openebs_queue_depth",
+					Priority:  0.7,
+					Max:       &max,
+					Round:     true,
+					Aggregate: AggregateAverage,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "when catalog is JSON",
+			file:    "catalog.json",
+			content: `[{"id":"capacity","name":"capacityQuery","query":"openebs_size_of_volume","format":"bytes"}]`,
+			want: Catalog{
+				{
+					ID:     "capacity",
+					Name:   "capacityQuery",
+					PromQL: "openebs_size_of_volume",
+					Format: "bytes",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "when catalog is empty",
+			file:    "catalog.yaml",
+			content: `[]`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "when query is missing",
+			file:    "catalog.yaml",
+			content: `[{"id":"capacity","name":"capacityQuery"}]`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "when ids are duplicated",
+			file:    "catalog.yaml",
+			content: `[{"id":"a","name":"a","query":"a"},{"id":"a","name":"b","query":"b"}]`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "when weightedBy is unknown",
+			file:    "catalog.yaml",
+			content: `[{"id":"a","name":"a","query":"a","aggregate":"average","weightedBy":"b"}]`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "when topology is unknown",
+			file:    "catalog.yaml",
+			content: `[{"id":"a","name":"a","query":"a","topology":"disk"}]`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "when weightedBy is in another topology",
+			file:    "catalog.yaml",
+			content: `[{"id":"a","name":"a","query":"a","topology":"pool"},{"id":"b","name":"b","query":"b","aggregate":"average","weightedBy":"a"}]`,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCatalog(t, tt.file, tt.content)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			got, err := LoadCatalog(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadCatalog() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadCatalog() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	if err := DefaultCatalog().Validate(); err != nil {
+		t.Errorf("DefaultCatalog().Validate() error = %v", err)
+	}
+}
+
+func TestPVMetrics_metricsWithCustomCatalog(t *testing.T) {
+	max := float64(64)
+	p := &PVMetrics{
+		Catalog: Catalog{
+			{
+				ID:       "queueDepth",
+				Name:     "queueDepthQuery",
+				Label:    "Queue Depth",
+				PromQL:   "openebs_queue_depth",
+				Priority: 0.7,
+				Max:      &max,
+				Round:    true,
+			},
+		},
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+
+	wantTemplates := map[string]metricTemplate{
+		"queueDepth": {
+			ID:       "queueDepth",
+			Label:    "Queue Depth",
+			Priority: 0.7,
+		},
+	}
+	if got := p.metricTemplates(p.Snapshot()); !reflect.DeepEqual(got, wantTemplates) {
+		t.Errorf("PVMetrics.metricTemplates() = %v, want %v", got, wantTemplates)
+	}
+
+	got := p.metrics([]float64{3.6})
+	if got["queueDepth"].Samples[0].Value != 4 || got["queueDepth"].Max != 64 {
+		t.Errorf("PVMetrics.metrics() = %v, want value 4 and max 64", got)
+	}
+}
+
+func TestLoadCatalogExample(t *testing.T) {
+	got, err := LoadCatalog("../deployment/query-catalog.yaml")
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if want := DefaultCatalog(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadCatalog() = %v, want %v", got, want)
+	}
+}
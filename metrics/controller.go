@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// TakeSnapshotControl is the ID of the control taking a snapshot of a volume.
+	TakeSnapshotControl = "takeSnapshot"
+	// ExpandVolumeControl is the ID of the control expanding the PVC of a volume.
+	ExpandVolumeControl = "expandVolume"
+	// DefaultExpandSize is the default size in GiB added by the expand control.
+	DefaultExpandSize = 1
+)
+
+// VolumeSnapshotResource is the resource of the volume snapshots created by
+// the take snapshot control.
+var VolumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "volumesnapshot.external-storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// pvControls returns the controls of the PV nodes.
+func (p *PVMetrics) pvControls() map[string]control {
+	controls := p.pvcControls()
+	controls[TakeSnapshotControl] = control{
+		ID:    TakeSnapshotControl,
+		Human: "Take snapshot",
+		Icon:  "fa-camera",
+		Rank:  1,
+	}
+	return controls
+}
+
+// pvcControls returns the controls of the PVC nodes.
+func (p *PVMetrics) pvcControls() map[string]control {
+	return map[string]control{
+		ExpandVolumeControl: {
+			ID:    ExpandVolumeControl,
+			Human: fmt.Sprintf("Expand +%d Gi", p.expandSize()),
+			Icon:  "fa-expand",
+			Rank:  2,
+		},
+	}
+}
+
+// pvLatestControls returns the state of the controls of a PV node. The
+// controls are disabled for PVs which are not bound to a PVC, and the expand
+// control for PVs whose StorageClass does not allow volume expansion.
+func pvLatestControls(bound, expandable bool) map[string]controlEntry {
+	now := time.Now()
+	return map[string]controlEntry{
+		TakeSnapshotControl: {
+			Timestamp: now,
+			Value: controlData{
+				Dead: !bound,
+			},
+		},
+		ExpandVolumeControl: {
+			Timestamp: now,
+			Value: controlData{
+				Dead: !bound || !expandable,
+			},
+		},
+	}
+}
+
+// pvcLatestControls returns the state of the controls of a PVC node. The
+// expand control is disabled if the StorageClass of the PVC does not allow
+// volume expansion.
+func pvcLatestControls(expandable bool) map[string]controlEntry {
+	return map[string]controlEntry{
+		ExpandVolumeControl: {
+			Timestamp: time.Now(),
+			Value: controlData{
+				Dead: !expandable,
+			},
+		},
+	}
+}
+
+// expandSize returns the size in GiB added by the expand control.
+func (p *PVMetrics) expandSize() int64 {
+	if p.ExpandSize <= 0 {
+		return DefaultExpandSize
+	}
+	return p.ExpandSize
+}
+
+// Control is called by scope when a control of a node is activated. It is
+// part of the "controller" interface.
+func (p *PVMetrics) Control(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Errorf("Bad control request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := response{}
+	if err := p.control(req); err != nil {
+		log.Errorf("Control %s on %s failed: %v", req.Control, req.NodeID, err)
+		res.Error = err.Error()
+	} else {
+		res.ShortcutReport = p.makeReport()
+	}
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		log.Errorf("error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(raw)
+}
+
+// control runs the requested control on the node.
+func (p *PVMetrics) control(req request) error {
+	pvName, err := p.pvName(req.NodeID)
+	if err != nil {
+		return err
+	}
+
+	switch req.Control {
+	case TakeSnapshotControl:
+		_, err = p.TakeSnapshot(pvName)
+	case ExpandVolumeControl:
+		_, err = p.ExpandVolume(pvName, *resource.NewQuantity(p.expandSize()<<30, resource.BinarySI))
+	default:
+		err = fmt.Errorf("unknown control %q", req.Control)
+	}
+	return err
+}
+
+// pvName returns the name of the PV of the given PV or PVC node ID.
+func (p *PVMetrics) pvName(nodeID string) (string, error) {
+	s := p.Snapshot()
+	switch {
+	case strings.HasSuffix(nodeID, ";<persistent_volume>"):
+		pvUID := strings.TrimSuffix(nodeID, ";<persistent_volume>")
+		for pvName, uid := range s.PVList {
+			if uid == pvUID {
+				return pvName, nil
+			}
+		}
+		return "", fmt.Errorf("PV %q not found", pvUID)
+	case strings.HasSuffix(nodeID, ";<persistent_volume_claim>"):
+		pvcUID := strings.TrimSuffix(nodeID, ";<persistent_volume_claim>")
+		for pvName, uid := range s.PVCList {
+			if uid == pvcUID {
+				return pvName, nil
+			}
+		}
+		return "", fmt.Errorf("PVC %q not found", pvcUID)
+	default:
+		return "", fmt.Errorf("%q is not a PV or PVC node", nodeID)
+	}
+}
+
+// TakeSnapshot creates a VolumeSnapshot of the PVC bound to the given PV and
+// returns its name.
+func (p *PVMetrics) TakeSnapshot(pvName string) (string, error) {
+	if p.DynamicClient == nil {
+		return "", errors.New("snapshots are not supported without a dynamic client")
+	}
+
+	pv, err := p.ClientSet.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		p.stats.apiError("persistentvolumes", err)
+		return "", err
+	}
+	if pv.Spec.ClaimRef == nil {
+		return "", fmt.Errorf("PV %s is not bound to a PVC", pvName)
+	}
+
+	claim := pv.Spec.ClaimRef
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": VolumeSnapshotResource.GroupVersion().String(),
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				// The API server appends a random suffix, so that snapshots
+				// taken in quick succession do not collide.
+				"generateName": claim.Name + "-",
+				"namespace":    claim.Namespace,
+			},
+			"spec": map[string]interface{}{
+				"persistentVolumeClaimName": claim.Name,
+			},
+		},
+	}
+
+	created, err := p.DynamicClient.Resource(VolumeSnapshotResource).Namespace(claim.Namespace).Create(snapshot)
+	if err != nil {
+		p.stats.apiError(VolumeSnapshotResource.Resource, err)
+		return "", fmt.Errorf("failed to create snapshot of PVC %s/%s: %v", claim.Namespace, claim.Name, err)
+	}
+	log.Infof("Created snapshot %s/%s of PV %s", claim.Namespace, created.GetName(), pvName)
+	return created.GetName(), nil
+}
+
+// ExpandVolume adds size to the storage requested by the PVC bound to the
+// given PV and returns the new request. The StorageClass of the PVC must
+// allow volume expansion.
+func (p *PVMetrics) ExpandVolume(pvName string, size resource.Quantity) (resource.Quantity, error) {
+	pv, err := p.ClientSet.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		p.stats.apiError("persistentvolumes", err)
+		return resource.Quantity{}, err
+	}
+	if pv.Spec.ClaimRef == nil {
+		return resource.Quantity{}, fmt.Errorf("PV %s is not bound to a PVC", pvName)
+	}
+
+	claim := pv.Spec.ClaimRef
+	pvc, err := p.ClientSet.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if err != nil {
+		p.stats.apiError("persistentvolumeclaims", err)
+		return resource.Quantity{}, err
+	}
+	// The claim may have been deleted and recreated with the same name, and
+	// bound to another PV since.
+	if pvc.UID != claim.UID || pvc.Spec.VolumeName != pvName {
+		return resource.Quantity{}, fmt.Errorf("PVC %s/%s is not bound to PV %s", claim.Namespace, claim.Name, pvName)
+	}
+
+	scName := pv.Spec.StorageClassName
+	if pvc.Spec.StorageClassName != nil {
+		scName = *pvc.Spec.StorageClassName
+	}
+	if scName == "" {
+		return resource.Quantity{}, fmt.Errorf("PVC %s/%s has no StorageClass, volume expansion is not allowed", claim.Namespace, claim.Name)
+	}
+	sc, err := p.ClientSet.StorageV1().StorageClasses().Get(scName, metav1.GetOptions{})
+	if err != nil {
+		p.stats.apiError("storageclasses", err)
+		return resource.Quantity{}, err
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return resource.Quantity{}, fmt.Errorf("StorageClass %s does not allow volume expansion", scName)
+	}
+
+	storage := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	storage.Add(size)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{
+					string(corev1.ResourceStorage): storage.String(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+
+	_, err = p.ClientSet.CoreV1().PersistentVolumeClaims(claim.Namespace).Patch(claim.Name, types.StrategicMergePatchType, patch)
+	if err != nil {
+		p.stats.apiError("persistentvolumeclaims", err)
+		return resource.Quantity{}, fmt.Errorf("failed to expand PVC %s/%s: %v", claim.Namespace, claim.Name, err)
+	}
+	log.Infof("Expanded PVC %s/%s of PV %s to %s", claim.Namespace, claim.Name, pvName, storage.String())
+	return storage, nil
+}
@@ -0,0 +1,327 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPVMetrics_Control(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV",
+				UID:  "pv1234",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: "default",
+					Name:      "testPVC",
+					UID:       "pvc1234",
+				},
+			},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV1",
+				UID:  "pv5678",
+			},
+		},
+	)
+	tests := []struct {
+		name      string
+		request   request
+		dynamic   bool
+		wantError bool
+	}{
+		{
+			name:    "when the PV is bound",
+			request: request{NodeID: "pv1234;<persistent_volume>", Control: TakeSnapshotControl},
+			dynamic: true,
+		},
+		{
+			name:      "when the PV is not bound",
+			request:   request{NodeID: "pv5678;<persistent_volume>", Control: TakeSnapshotControl},
+			dynamic:   true,
+			wantError: true,
+		},
+		{
+			name:      "when the node is not a PV",
+			request:   request{NodeID: "pod1234;<pod>", Control: TakeSnapshotControl},
+			dynamic:   true,
+			wantError: true,
+		},
+		{
+			name:      "when the control is unknown",
+			request:   request{NodeID: "pv1234;<persistent_volume>", Control: "unknown"},
+			dynamic:   true,
+			wantError: true,
+		},
+		{
+			name:      "when there is no dynamic client",
+			request:   request{NodeID: "pv1234;<persistent_volume>", Control: TakeSnapshotControl},
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			p := &PVMetrics{
+				ClientSet: clientSet,
+			}
+			if tt.dynamic {
+				p.DynamicClient = dynamicClient
+			}
+			p.snapshot.Store(&Snapshot{
+				PVList: map[string]string{
+					"testPV":  "pv1234",
+					"testPV1": "pv5678",
+				},
+				PVCList: map[string]string{
+					"testPV": "pvc1234",
+				},
+			})
+
+			body, _ := json.Marshal(tt.request)
+			w := httptest.NewRecorder()
+			p.Control(w, httptest.NewRequest(http.MethodPost, "/control", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("PVMetrics.Control() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			var res response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if (res.Error != "") != tt.wantError {
+				t.Errorf("PVMetrics.Control() error = %q, wantError %v", res.Error, tt.wantError)
+			}
+			if !tt.wantError && res.ShortcutReport == nil {
+				t.Errorf("PVMetrics.Control() returned no shortcut report")
+			}
+
+			var created []*unstructured.Unstructured
+			for _, action := range dynamicClient.Actions() {
+				if action, ok := action.(k8stesting.CreateAction); ok {
+					created = append(created, action.GetObject().(*unstructured.Unstructured))
+				}
+			}
+			if tt.wantError {
+				if len(created) != 0 {
+					t.Errorf("PVMetrics.Control() created %d snapshots, want none", len(created))
+				}
+				return
+			}
+			if len(created) != 1 {
+				t.Fatalf("PVMetrics.Control() created %d snapshots, want 1", len(created))
+			}
+			claimName, _, _ := unstructured.NestedString(created[0].Object, "spec", "persistentVolumeClaimName")
+			if created[0].GetNamespace() != "default" || claimName != "testPVC" {
+				t.Errorf("PVMetrics.Control() created snapshot of %s/%s, want default/testPVC", created[0].GetNamespace(), claimName)
+			}
+			if created[0].GetGenerateName() != "testPVC-" || created[0].GetName() != "" {
+				t.Errorf("PVMetrics.Control() created snapshot %q, want a name generated from testPVC-", created[0].GetName()+created[0].GetGenerateName())
+			}
+		})
+	}
+}
+
+func TestPVMetrics_TakeSnapshotAPIError(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "testPV",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{
+				Namespace: "default",
+				Name:      "testPVC",
+			},
+		},
+	})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dynamicClient.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("volumesnapshots is forbidden")
+	})
+	p := &PVMetrics{
+		ClientSet:     clientSet,
+		DynamicClient: dynamicClient,
+	}
+
+	if _, err := p.TakeSnapshot("testPV"); err == nil {
+		t.Fatal("PVMetrics.TakeSnapshot() error = nil, want the create error")
+	}
+	if _, err := p.TakeSnapshot("missingPV"); err == nil {
+		t.Fatal("PVMetrics.TakeSnapshot() error = nil, want the not found error")
+	}
+	// The missing PV is not an API error.
+	want := map[string]float64{"volumesnapshots": 1}
+	if !reflect.DeepEqual(p.stats.apiErrors, want) {
+		t.Errorf("PVMetrics.TakeSnapshot() API errors = %v, want %v", p.stats.apiErrors, want)
+	}
+}
+
+func TestPVMetrics_ControlBadRequest(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: fake.NewSimpleClientset(),
+	}
+	w := httptest.NewRecorder()
+	p.Control(w, httptest.NewRequest(http.MethodPost, "/control", bytes.NewReader([]byte("{"))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PVMetrics.Control() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPVMetrics_ExpandVolume(t *testing.T) {
+	allowed, notAllowed := true, false
+	newClientSet := func() *fake.Clientset {
+		return fake.NewSimpleClientset(
+			&corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "testPV",
+					UID:  "pv1234",
+				},
+				Spec: corev1.PersistentVolumeSpec{
+					StorageClassName: "openebs-cstor",
+					ClaimRef: &corev1.ObjectReference{
+						Namespace: "default",
+						Name:      "testPVC",
+						UID:       "pvc1234",
+					},
+				},
+			},
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "testPVC",
+					UID:       "pvc1234",
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					VolumeName: "testPV",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceStorage: resource.MustParse("4Gi"),
+						},
+					},
+				},
+			},
+			&storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "openebs-cstor",
+				},
+				AllowVolumeExpansion: &allowed,
+			},
+			&storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "openebs-jiva",
+				},
+				AllowVolumeExpansion: &notAllowed,
+			},
+		)
+	}
+	tests := []struct {
+		name         string
+		storageClass string
+		pvcUID       types.UID
+		volumeName   string
+		nodeID       string
+		wantError    string
+		wantStorage  string
+	}{
+		{
+			name:         "when the StorageClass allows expansion",
+			storageClass: "openebs-cstor",
+			nodeID:       "pv1234;<persistent_volume>",
+			wantStorage:  "6Gi",
+		},
+		{
+			name:         "when the control is activated on the PVC",
+			storageClass: "openebs-cstor",
+			nodeID:       "pvc1234;<persistent_volume_claim>",
+			wantStorage:  "6Gi",
+		},
+		{
+			name:         "when the StorageClass does not allow expansion",
+			storageClass: "openebs-jiva",
+			nodeID:       "pv1234;<persistent_volume>",
+			wantError:    "StorageClass openebs-jiva does not allow volume expansion",
+			wantStorage:  "4Gi",
+		},
+		{
+			name:         "when the PVC was recreated",
+			storageClass: "openebs-cstor",
+			pvcUID:       "pvc5678",
+			nodeID:       "pv1234;<persistent_volume>",
+			wantError:    "PVC default/testPVC is not bound to PV testPV",
+			wantStorage:  "4Gi",
+		},
+		{
+			name:         "when the PVC is bound to another PV",
+			storageClass: "openebs-cstor",
+			volumeName:   "testPV1",
+			nodeID:       "pv1234;<persistent_volume>",
+			wantError:    "PVC default/testPVC is not bound to PV testPV",
+			wantStorage:  "4Gi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := newClientSet()
+			pvc, _ := clientSet.CoreV1().PersistentVolumeClaims("default").Get("testPVC", metav1.GetOptions{})
+			pvc.Spec.StorageClassName = &tt.storageClass
+			if tt.pvcUID != "" {
+				pvc.UID = tt.pvcUID
+			}
+			if tt.volumeName != "" {
+				pvc.Spec.VolumeName = tt.volumeName
+			}
+			clientSet.CoreV1().PersistentVolumeClaims("default").Update(pvc)
+
+			p := &PVMetrics{
+				ClientSet:  clientSet,
+				ExpandSize: 2,
+			}
+			p.snapshot.Store(&Snapshot{
+				PVList: map[string]string{
+					"testPV": "pv1234",
+				},
+				PVCList: map[string]string{
+					"testPV": "pvc1234",
+				},
+			})
+
+			body, _ := json.Marshal(request{NodeID: tt.nodeID, Control: ExpandVolumeControl})
+			w := httptest.NewRecorder()
+			p.Control(w, httptest.NewRequest(http.MethodPost, "/control", bytes.NewReader(body)))
+			var res response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error != tt.wantError {
+				t.Errorf("PVMetrics.Control() error = %q, want %q", res.Error, tt.wantError)
+			}
+
+			pvc, err := clientSet.CoreV1().PersistentVolumeClaims("default").Get("testPVC", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			if want := resource.MustParse(tt.wantStorage); storage.Cmp(want) != 0 {
+				t.Errorf("PVC storage = %s, want %s", storage.String(), tt.wantStorage)
+			}
+		})
+	}
+}
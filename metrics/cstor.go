@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// replicasPrefix is the prefix of the latest keys shown in the replica table.
+	replicasPrefix = "openebs_replica_"
+	// replicasTable is the ID of the replica table.
+	replicasTable = "openebs_replicas"
+	// replicaHealth is the latest key of the replica health summary.
+	replicaHealth = "openebs_health"
+	// healthyPhase is the phase of healthy cStor volumes and replicas.
+	healthyPhase = "Healthy"
+)
+
+var (
+	// CStorVolumeResource is the resource of the cStor volumes.
+	CStorVolumeResource = schema.GroupVersionResource{
+		Group:    "openebs.io",
+		Version:  "v1alpha1",
+		Resource: "cstorvolumes",
+	}
+	// CStorVolumeReplicaResource is the resource of the cStor volume replicas.
+	CStorVolumeReplicaResource = schema.GroupVersionResource{
+		Group:    "openebs.io",
+		Version:  "v1alpha1",
+		Resource: "cstorvolumereplicas",
+	}
+)
+
+// CStorVolume describes a cStor volume and its replicas.
+type CStorVolume struct {
+	Phase             string
+	ReplicationFactor int64
+	Replicas          []CStorReplica
+}
+
+// CStorReplica describes a replica of a cStor volume.
+type CStorReplica struct {
+	Name     string
+	Pool     string
+	Host     string
+	Phase    string
+	Capacity string
+}
+
+// GetCStorVolumeList fetch and update the cStor volumes and replicas of each PV.
+// Clusters without cStor, where the custom resources are not defined, have no
+// cStor volumes. Once the informers are started, the volumes are updated by
+// their events and GetCStorVolumeList does nothing.
+func (p *PVMetrics) GetCStorVolumeList() {
+	if p.DynamicClient == nil || p.listers != nil {
+		return
+	}
+
+	volumeList, err := p.DynamicClient.Resource(CStorVolumeResource).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		p.stats.apiError(CStorVolumeResource.Resource, err)
+		log.Debugf("Failed to list cStor volumes: %v", err)
+		return
+	}
+	replicaList, err := p.DynamicClient.Resource(CStorVolumeReplicaResource).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		p.stats.apiError(CStorVolumeReplicaResource.Resource, err)
+		log.Debugf("Failed to list cStor volume replicas: %v", err)
+		return
+	}
+
+	cstorVolumes := p.PVNameAndCStorVolume(volumeList.Items, replicaList.Items)
+	p.update(func(s *Snapshot) {
+		s.CStorVolumes = cstorVolumes
+	})
+}
+
+// syncCStorVolumes updates the cStor volumes from the informer caches.
+func (p *PVMetrics) syncCStorVolumes() {
+	if p.listers.cstorVolume == nil {
+		return
+	}
+	cstorVolumes := p.PVNameAndCStorVolume(unstructuredItems(p.listers.cstorVolume), unstructuredItems(p.listers.cstorReplica))
+	p.update(func(s *Snapshot) {
+		s.CStorVolumes = cstorVolumes
+	})
+}
+
+// PVNameAndCStorVolume returns the cStor volume of each PV with its replicas,
+// keyed by PV name.
+func (p *PVMetrics) PVNameAndCStorVolume(volumeItems, replicaItems []unstructured.Unstructured) map[string]CStorVolume {
+	cstorVolumes := make(map[string]CStorVolume)
+	for _, volume := range volumeItems {
+		pvName := volume.GetLabels()["openebs.io/persistent-volume"]
+		if pvName == "" {
+			pvName = volume.GetName()
+		}
+		phase, _, _ := unstructured.NestedString(volume.Object, "status", "phase")
+		replicationFactor, _, _ := unstructured.NestedInt64(volume.Object, "spec", "replicationFactor")
+		cstorVolumes[pvName] = CStorVolume{
+			Phase:             phase,
+			ReplicationFactor: replicationFactor,
+		}
+	}
+
+	for _, replica := range replicaItems {
+		pvName := replica.GetLabels()["cstorvolume.openebs.io/name"]
+		if pvName == "" {
+			pvName = replica.GetLabels()["openebs.io/persistent-volume"]
+		}
+		volume, ok := cstorVolumes[pvName]
+		if !ok {
+			continue
+		}
+		phase, _, _ := unstructured.NestedString(replica.Object, "status", "phase")
+		capacity, _, _ := unstructured.NestedString(replica.Object, "spec", "capacity")
+		volume.Replicas = append(volume.Replicas, CStorReplica{
+			Name:     replica.GetName(),
+			Pool:     replica.GetLabels()["cstorpool.openebs.io/name"],
+			Host:     replica.GetAnnotations()["cstorpool.openebs.io/hostname"],
+			Phase:    phase,
+			Capacity: capacity,
+		})
+		cstorVolumes[pvName] = volume
+	}
+
+	for pvName, volume := range cstorVolumes {
+		sort.Slice(volume.Replicas, func(i, j int) bool {
+			return volume.Replicas[i].Name < volume.Replicas[j].Name
+		})
+		cstorVolumes[pvName] = volume
+	}
+	return cstorVolumes
+}
+
+// Health returns a summary of the health of the volume replicas, such as
+// "Degraded, 2/3 replicas healthy".
+func (v CStorVolume) Health() string {
+	healthy := 0
+	for _, replica := range v.Replicas {
+		if replica.Phase == healthyPhase {
+			healthy++
+		}
+	}
+	replicas := int64(len(v.Replicas))
+	if v.ReplicationFactor > replicas {
+		replicas = v.ReplicationFactor
+	}
+
+	phase := v.Phase
+	if phase == "" {
+		phase = "Unknown"
+	}
+	return fmt.Sprintf("%s, %d/%d replicas healthy", phase, healthy, replicas)
+}
+
+// latest returns the latest entries of the replica table and health summary.
+func (v CStorVolume) latest() map[string]latestEntry {
+	now := time.Now()
+	latest := map[string]latestEntry{
+		replicaHealth: {
+			Timestamp: now,
+			Value:     v.Health(),
+		},
+	}
+	for _, replica := range v.Replicas {
+		columns := map[string]string{
+			"name":     replica.Name,
+			"pool":     replica.Pool,
+			"host":     replica.Host,
+			"phase":    replica.Phase,
+			"capacity": replica.Capacity,
+		}
+		for column, value := range columns {
+			latest[replicasPrefix+replica.Name+"___"+column] = latestEntry{
+				Timestamp: now,
+				Value:     value,
+			}
+		}
+	}
+	return latest
+}
+
+// replicasTableTemplate returns the template of the replica table.
+func replicasTableTemplate() tableTemplate {
+	return tableTemplate{
+		ID:     replicasTable,
+		Label:  "cStor replicas",
+		Prefix: replicasPrefix,
+		Type:   multiColumnTableType,
+		Columns: []column{
+			{ID: "name", Label: "Name"},
+			{ID: "pool", Label: "Pool"},
+			{ID: "host", Label: "Host"},
+			{ID: "phase", Label: "Phase"},
+			{ID: "capacity", Label: "Capacity"},
+		},
+	}
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestPVMetrics_GetCStorVolumeList(t *testing.T) {
+	volumes := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeList","items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolume","metadata":{"name":"testPV","namespace":"openebs","labels":{"openebs.io/persistent-volume":"testPV"}},"spec":{"replicationFactor":3},"status":{"phase":"Degraded"}}]}`
+	replicas := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplicaList","items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplica","metadata":{"name":"testPV-pool-b","namespace":"openebs","labels":{"cstorvolume.openebs.io/name":"testPV","cstorpool.openebs.io/name":"pool-b"},"annotations":{"cstorpool.openebs.io/hostname":"node-b"}},"spec":{"capacity":"5G"},"status":{"phase":"Offline"}},
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplica","metadata":{"name":"testPV-pool-a","namespace":"openebs","labels":{"cstorvolume.openebs.io/name":"testPV","cstorpool.openebs.io/name":"pool-a"},"annotations":{"cstorpool.openebs.io/hostname":"node-a"}},"spec":{"capacity":"5G"},"status":{"phase":"Healthy"}},
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplica","metadata":{"name":"otherPV-pool-a","namespace":"openebs","labels":{"cstorvolume.openebs.io/name":"otherPV"}},"spec":{"capacity":"5G"},"status":{"phase":"Healthy"}}]}`
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/openebs.io/v1alpha1/cstorvolumes":
+			w.Write([]byte(volumes))
+		case "/apis/openebs.io/v1alpha1/cstorvolumereplicas":
+			w.Write([]byte(replicas))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer testServer.Close()
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: testServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PVMetrics{
+		DynamicClient: dynamicClient,
+	}
+	p.GetCStorVolumeList()
+
+	want := map[string]CStorVolume{
+		"testPV": {
+			Phase:             "Degraded",
+			ReplicationFactor: 3,
+			Replicas: []CStorReplica{
+				{Name: "testPV-pool-a", Pool: "pool-a", Host: "node-a", Phase: "Healthy", Capacity: "5G"},
+				{Name: "testPV-pool-b", Pool: "pool-b", Host: "node-b", Phase: "Offline", Capacity: "5G"},
+			},
+		},
+	}
+	got := p.Snapshot().CStorVolumes
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Snapshot().CStorVolumes = %+v, want %+v", got, want)
+	}
+	if health, want := got["testPV"].Health(), "Degraded, 1/3 replicas healthy"; health != want {
+		t.Errorf("CStorVolume.Health() = %q, want %q", health, want)
+	}
+
+	latest := got["testPV"].latest()
+	if value := latest[replicasPrefix+"testPV-pool-b___host"].Value; value != "node-b" {
+		t.Errorf("replica host = %q, want node-b", value)
+	}
+	if value := latest[replicaHealth].Value; value != "Degraded, 1/3 replicas healthy" {
+		t.Errorf("replica health = %q, want the health summary", value)
+	}
+}
+
+func TestPVMetrics_GetCStorVolumeListWithoutCStor(t *testing.T) {
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	defer testServer.Close()
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: testServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PVMetrics{
+		DynamicClient: dynamicClient,
+	}
+	p.GetCStorVolumeList()
+	if got := p.Snapshot().CStorVolumes; got != nil {
+		t.Errorf("Snapshot().CStorVolumes = %v, want nil", got)
+	}
+}
+
+func TestPVMetrics_StartInformersWithCStor(t *testing.T) {
+	volumes := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeList","metadata":{"resourceVersion":"1"},"items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolume","metadata":{"name":"testPV","namespace":"openebs","labels":{"openebs.io/persistent-volume":"testPV"}},"spec":{"replicationFactor":1},"status":{"phase":"Healthy"}}]}`
+	replicas := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplicaList","metadata":{"resourceVersion":"1"},"items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorVolumeReplica","metadata":{"name":"testPV-pool-a","namespace":"openebs","labels":{"cstorvolume.openebs.io/name":"testPV","cstorpool.openebs.io/name":"pool-a"}},"spec":{"capacity":"5G"},"status":{"phase":"Healthy"}}]}`
+	pools := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorPoolList","metadata":{"resourceVersion":"1"},"items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorPool","metadata":{"name":"pool-a","uid":"pool1234","labels":{"kubernetes.io/hostname":"node-a"}},"status":{"phase":"Healthy","capacity":{"total":"10G","used":"4G","free":"6G"}}}]}`
+
+	tests := []struct {
+		name      string
+		defined   bool
+		want      map[string]CStorVolume
+		wantPools map[string]CStorPool
+	}{
+		{
+			name:    "when cStor is installed",
+			defined: true,
+			want: map[string]CStorVolume{
+				"testPV": {
+					Phase:             "Healthy",
+					ReplicationFactor: 1,
+					Replicas: []CStorReplica{
+						{Name: "testPV-pool-a", Pool: "pool-a", Phase: "Healthy", Capacity: "5G"},
+					},
+				},
+			},
+			wantPools: map[string]CStorPool{
+				"pool-a": {UID: "pool1234", Host: "node-a", Phase: "Healthy", Total: "10G", Used: "4G", Free: "6G"},
+			},
+		},
+		{
+			name:      "when cStor is not installed",
+			defined:   false,
+			want:      nil,
+			wantPools: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var watches int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.defined {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Query().Get("watch") == "true" {
+					// The watch stays open without events until it is stopped.
+					atomic.AddInt32(&watches, 1)
+					w.WriteHeader(http.StatusOK)
+					w.(http.Flusher).Flush()
+					<-r.Context().Done()
+					return
+				}
+				switch r.URL.Path {
+				case "/apis/openebs.io/v1alpha1/cstorvolumes":
+					w.Write([]byte(volumes))
+				case "/apis/openebs.io/v1alpha1/cstorvolumereplicas":
+					w.Write([]byte(replicas))
+				case "/apis/openebs.io/v1alpha1/cstorpools":
+					w.Write([]byte(pools))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer testServer.Close()
+
+			dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: testServer.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &PVMetrics{
+				ClientSet:     fake.NewSimpleClientset(),
+				DynamicClient: dynamicClient,
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			if err := p.StartInformers(stopCh); err != nil {
+				t.Fatalf("PVMetrics.StartInformers() error = %v", err)
+			}
+			if got := p.Snapshot().CStorVolumes; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Snapshot().CStorVolumes = %+v, want %+v", got, tt.want)
+			}
+			if got := p.Snapshot().CStorPools; !reflect.DeepEqual(got, tt.wantPools) {
+				t.Errorf("Snapshot().CStorPools = %+v, want %+v", got, tt.wantPools)
+			}
+			// The resources missing from the cluster are not watched.
+			if got := atomic.LoadInt32(&watches); !tt.defined && got != 0 {
+				t.Errorf("PVMetrics.StartInformers() made %d watch calls, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// detailsPrefix is the prefix of the latest keys shown in the volume details table.
+	detailsPrefix = "openebs_volume_"
+	// detailsTable is the ID of the volume details table.
+	detailsTable = "openebs_volume_details"
+)
+
+// PVDetails describes a PV in the node panel of scope.
+type PVDetails struct {
+	Capacity       string
+	AccessModes    string
+	ReclaimPolicy  string
+	Phase          string
+	StorageClass   string
+	Claim          string
+	ClaimNamespace string
+	StorageEngine  string
+	TargetPod      string
+}
+
+// PVNameAndDetails returns the details of each PV, keyed by PV name. The
+// target pod is only found if it is part of podListItems.
+func (p *PVMetrics) PVNameAndDetails(pvListItems []corev1.PersistentVolume, podListItems []corev1.Pod) map[string]PVDetails {
+	targetPods := make(map[string]string)
+	for _, pod := range podListItems {
+		pvName := pod.GetLabels()["openebs.io/persistent-volume"]
+		if pvName == "" || !isTargetPod(pod) {
+			continue
+		}
+		targetPods[pvName] = pod.GetNamespace() + "/" + pod.GetName()
+	}
+
+	pvDetails := make(map[string]PVDetails)
+	for _, pv := range pvListItems {
+		details := PVDetails{
+			AccessModes:   accessModes(pv.Spec.AccessModes),
+			ReclaimPolicy: string(pv.Spec.PersistentVolumeReclaimPolicy),
+			Phase:         string(pv.Status.Phase),
+			StorageClass:  pv.Spec.StorageClassName,
+			StorageEngine: storageEngine(pv),
+			TargetPod:     targetPods[pv.GetName()],
+		}
+		if capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+			details.Capacity = capacity.String()
+		}
+		if pv.Spec.ClaimRef != nil {
+			details.Claim = pv.Spec.ClaimRef.Name
+			details.ClaimNamespace = pv.Spec.ClaimRef.Namespace
+		}
+		pvDetails[pv.GetName()] = details
+	}
+	return pvDetails
+}
+
+// isTargetPod reports whether the pod is the target of an OpenEBS volume.
+func isTargetPod(pod corev1.Pod) bool {
+	labels := pod.GetLabels()
+	return labels["openebs.io/target"] != "" || labels["openebs.io/controller"] != ""
+}
+
+// storageEngine returns the OpenEBS storage engine of the PV, falling back
+// to its provisioner.
+func storageEngine(pv corev1.PersistentVolume) string {
+	if casType := pv.GetLabels()["openebs.io/cas-type"]; casType != "" {
+		return casType
+	}
+	if casType := pv.GetAnnotations()["openebs.io/cas-type"]; casType != "" {
+		return casType
+	}
+	return pv.GetAnnotations()["pv.kubernetes.io/provisioned-by"]
+}
+
+// accessModes returns the short names of the access modes, e.g. "RWO, ROX".
+func accessModes(modes []corev1.PersistentVolumeAccessMode) string {
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		switch mode {
+		case corev1.ReadWriteOnce:
+			names = append(names, "RWO")
+		case corev1.ReadOnlyMany:
+			names = append(names, "ROX")
+		case corev1.ReadWriteMany:
+			names = append(names, "RWX")
+		default:
+			names = append(names, string(mode))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// latest returns the latest entries of a PV node. Empty details are left out.
+func (d PVDetails) latest() map[string]latestEntry {
+	now := time.Now()
+	values := map[string]string{
+		"openebs_capacity":                d.Capacity,
+		"openebs_phase":                   d.Phase,
+		"openebs_storage_class":           d.StorageClass,
+		"openebs_claim":                   d.Claim,
+		detailsPrefix + "Access modes":    d.AccessModes,
+		detailsPrefix + "Reclaim policy":  d.ReclaimPolicy,
+		detailsPrefix + "Claim namespace": d.ClaimNamespace,
+		detailsPrefix + "Storage engine":  d.StorageEngine,
+		detailsPrefix + "Target pod":      d.TargetPod,
+	}
+
+	latest := make(map[string]latestEntry)
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+		latest[key] = latestEntry{
+			Timestamp: now,
+			Value:     value,
+		}
+	}
+	return latest
+}
+
+// pvMetadataTemplates returns the metadata shown on top of the PV node panel.
+func pvMetadataTemplates() map[string]metadataTemplate {
+	return map[string]metadataTemplate{
+		"openebs_capacity": {
+			ID:       "openebs_capacity",
+			Label:    "Capacity",
+			Priority: 1,
+			From:     "latest",
+		},
+		"openebs_phase": {
+			ID:       "openebs_phase",
+			Label:    "Phase",
+			Priority: 2,
+			From:     "latest",
+		},
+		"openebs_storage_class": {
+			ID:       "openebs_storage_class",
+			Label:    "StorageClass",
+			Priority: 3,
+			From:     "latest",
+		},
+		"openebs_claim": {
+			ID:       "openebs_claim",
+			Label:    "PVC",
+			Priority: 4,
+			From:     "latest",
+		},
+		replicaHealth: {
+			ID:       replicaHealth,
+			Label:    "Replicas",
+			Priority: 5,
+			From:     "latest",
+		},
+		alertsFiring: {
+			ID:       alertsFiring,
+			Label:    "Alerts",
+			Priority: 6,
+			From:     "latest",
+		},
+	}
+}
+
+// pvTableTemplates returns the tables of the PV node panel.
+func pvTableTemplates() map[string]tableTemplate {
+	return map[string]tableTemplate{
+		detailsTable: {
+			ID:     detailsTable,
+			Label:  "Volume details",
+			Prefix: detailsPrefix,
+			Type:   propertyListType,
+		},
+		replicasTable: replicasTableTemplate(),
+		alertsTable:   alertsTableTemplate(),
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPVMetrics_PVNameAndDetails(t *testing.T) {
+	pvListItems := []corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV1",
+				Labels: map[string]string{
+					"openebs.io/cas-type": "cstor",
+				},
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("5Gi"),
+				},
+				AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+				StorageClassName:              "openebs-cstor",
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: "default",
+					Name:      "testPVC1",
+				},
+			},
+			Status: corev1.PersistentVolumeStatus{
+				Phase: corev1.VolumeBound,
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV2",
+				Annotations: map[string]string{
+					"pv.kubernetes.io/provisioned-by": "openebs.io/provisioner-iscsi",
+				},
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany, corev1.ReadOnlyMany},
+			},
+			Status: corev1.PersistentVolumeStatus{
+				Phase: corev1.VolumeAvailable,
+			},
+		},
+	}
+	podListItems := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "openebs",
+				Name:      "testPV1-target",
+				Labels: map[string]string{
+					"openebs.io/persistent-volume": "testPV1",
+					"openebs.io/target":            "cstor-target",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "app",
+				Labels: map[string]string{
+					"openebs.io/persistent-volume": "testPV1",
+				},
+			},
+		},
+	}
+	want := map[string]PVDetails{
+		"testPV1": {
+			Capacity:       "5Gi",
+			AccessModes:    "RWO",
+			ReclaimPolicy:  "Delete",
+			Phase:          "Bound",
+			StorageClass:   "openebs-cstor",
+			Claim:          "testPVC1",
+			ClaimNamespace: "default",
+			StorageEngine:  "cstor",
+			TargetPod:      "openebs/testPV1-target",
+		},
+		"testPV2": {
+			AccessModes:   "ROX, RWX",
+			Phase:         "Available",
+			StorageEngine: "openebs.io/provisioner-iscsi",
+		},
+	}
+	p := &PVMetrics{}
+	if got := p.PVNameAndDetails(pvListItems, podListItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("PVMetrics.PVNameAndDetails() = %v, want %v", got, want)
+	}
+}
+
+func TestPVMetrics_makeReportWithDetails(t *testing.T) {
+	p := &PVMetrics{}
+	p.snapshot.Store(&Snapshot{
+		PVList: map[string]string{
+			"testPV": "abcdef1234",
+		},
+		PVCList: map[string]string{
+			"testPV": "pvc1234",
+		},
+		Details: map[string]PVDetails{
+			"testPV": {
+				Capacity:      "5Gi",
+				StorageEngine: "jiva",
+			},
+		},
+		Data: map[string]map[string]float64{},
+	})
+	got := p.makeReport()
+	for _, n := range []node{
+		got.PersistentVolume.Nodes["abcdef1234;<persistent_volume>"],
+		got.PersistentVolumeClaim.Nodes["pvc1234;<persistent_volume_claim>"],
+	} {
+		if len(n.Latest) != 2 {
+			t.Errorf("node latest = %v, want 2 entries", n.Latest)
+		}
+		if value := n.Latest["openebs_capacity"].Value; value != "5Gi" {
+			t.Errorf("node capacity = %q, want 5Gi", value)
+		}
+		if value := n.Latest[detailsPrefix+"Storage engine"].Value; value != "jiva" {
+			t.Errorf("node storage engine = %q, want jiva", value)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// expr is a PromQL expression evaluated locally on the scrapes of a target.
+// Only the subset used by the catalog is supported: numbers, metric names,
+// rate and irate of a metric over a range, sum by target labels, and
+// arithmetic.
+type expr interface {
+	// eval returns the value of the expression at scrapes[index], and false
+	// if a metric is missing.
+	eval(scrapes []scrape, index int) (float64, bool)
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(scrapes []scrape, index int) (float64, bool) {
+	return float64(e), true
+}
+
+type metricExpr string
+
+func (e metricExpr) eval(scrapes []scrape, index int) (float64, bool) {
+	value, ok := scrapes[index].values[string(e)]
+	return value, ok
+}
+
+// rateExpr is the per-second rate of a counter. irate uses the previous
+// scrape while rate uses the oldest scrape in the range.
+type rateExpr struct {
+	metric  string
+	window  time.Duration
+	instant bool
+}
+
+func (e rateExpr) eval(scrapes []scrape, index int) (float64, bool) {
+	current := scrapes[index]
+	last, ok := current.values[e.metric]
+	if !ok {
+		return 0, false
+	}
+
+	first := -1
+	for i := index - 1; i >= 0 && current.time.Sub(scrapes[i].time) <= e.window; i-- {
+		if _, ok := scrapes[i].values[e.metric]; ok {
+			first = i
+			if e.instant {
+				break
+			}
+		}
+	}
+	if first < 0 {
+		return 0, false
+	}
+
+	seconds := current.time.Sub(scrapes[first].time).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	increase := last - scrapes[first].values[e.metric]
+	if increase < 0 {
+		// The counter was reset.
+		increase = last
+	}
+	return increase / seconds, true
+}
+
+type binaryExpr struct {
+	op       byte
+	lhs, rhs expr
+}
+
+func (e binaryExpr) eval(scrapes []scrape, index int) (float64, bool) {
+	lhs, ok := e.lhs.eval(scrapes, index)
+	if !ok {
+		return 0, false
+	}
+	rhs, ok := e.rhs.eval(scrapes, index)
+	if !ok {
+		return 0, false
+	}
+	switch e.op {
+	case '+':
+		return lhs + rhs, true
+	case '-':
+		return lhs - rhs, true
+	case '*':
+		return lhs * rhs, true
+	default:
+		return lhs / rhs, true
+	}
+}
+
+// exprParser is a recursive descent parser of the supported PromQL subset.
+type exprParser struct {
+	query string
+	pos   int
+}
+
+// parseExpr parses query, failing on the PromQL features which are not
+// supported, such as label matchers and aggregations.
+func parseExpr(query string) (expr, error) {
+	p := &exprParser{query: query}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported query %q: %v", query, err)
+	}
+	if p.skipSpaces(); p.pos < len(p.query) {
+		return nil, fmt.Errorf("unsupported query %q: unexpected %q at %d", query, p.query[p.pos], p.pos)
+	}
+	return e, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+}
+
+// next returns the next character without consuming it, or 0 at the end.
+func (p *exprParser) next() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.query) {
+		return 0
+	}
+	return p.query[p.pos]
+}
+
+func (p *exprParser) expect(c byte) error {
+	if p.next() != c {
+		return fmt.Errorf("expected %q at %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	lhs, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	lhs, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		rhs, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseFactor() (expr, error) {
+	switch c := p.next(); {
+	case c == '(':
+		p.pos++
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(')')
+	case c == '-':
+		p.pos++
+		e, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op: '-', lhs: numberExpr(0), rhs: e}, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case isNameChar(c, true):
+		name := p.parseName()
+		if name == "sum" && p.next() == 'b' {
+			return p.parseSumBy()
+		}
+		if name != "rate" && name != "irate" {
+			if c := p.next(); c == '(' || c == '{' || c == '[' {
+				return nil, fmt.Errorf("unexpected %q after %s", c, name)
+			}
+			return metricExpr(name), nil
+		}
+		return p.parseRate(name == "irate")
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+	}
+}
+
+func (p *exprParser) parseNumber() (expr, error) {
+	start := p.pos
+	p.skipDigits()
+	// The exponent may be signed, as in 1e-6.
+	if p.pos < len(p.query) && (p.query[p.pos] == 'e' || p.query[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.query) && (p.query[p.pos] == '+' || p.query[p.pos] == '-') {
+			p.pos++
+		}
+		p.skipDigits()
+	}
+	value, err := strconv.ParseFloat(p.query[start:p.pos], 64)
+	if err != nil {
+		return nil, err
+	}
+	return numberExpr(value), nil
+}
+
+// skipDigits skips the digits and decimal points at the position of p.
+func (p *exprParser) skipDigits() {
+	for p.pos < len(p.query) && strings.IndexByte("0123456789.", p.query[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.query) && isNameChar(p.query[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+// parseRate parses the argument of rate or irate, e.g. "(openebs_reads[5m])".
+func (p *exprParser) parseRate(instant bool) (expr, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if !isNameChar(p.next(), true) {
+		return nil, fmt.Errorf("expected a metric name at %d", p.pos)
+	}
+	metric := p.parseName()
+	if err := p.expect('['); err != nil {
+		return nil, err
+	}
+	end := strings.IndexByte(p.query[p.pos:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range at %d", p.pos)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(p.query[p.pos : p.pos+end]))
+	if err != nil {
+		return nil, err
+	}
+	p.pos += end + 1
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return rateExpr{metric: metric, window: window, instant: instant}, nil
+}
+
+// parseSumBy parses the rest of a sum by labels, e.g.
+// "by (cstor_pool) (irate(openebs_total_read_count[5m]))". The values of a
+// target are already summed over its series, so the sum by the labels of the
+// targets is the expression itself.
+func (p *exprParser) parseSumBy() (expr, error) {
+	if p.parseName() != "by" {
+		return nil, fmt.Errorf("expected by at %d", p.pos)
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	for {
+		if !isNameChar(p.next(), true) {
+			return nil, fmt.Errorf("expected a label name at %d", p.pos)
+		}
+		p.parseName()
+		if p.next() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return p.parseFactor()
+}
+
+// isNameChar reports whether c may be part of a metric name, digits being
+// only allowed after the first character.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultProbeInterval is the default interval between two health probes of
+// the candidates of a FailoverSource.
+const DefaultProbeInterval = 30 * time.Second
+
+// FailoverSource queries the first healthy source of an ordered list of
+// candidates. The candidates are probed periodically and whenever the active
+// source fails, so that it fails over to the next healthy candidate and back
+// to a preferred one once it recovers.
+type FailoverSource struct {
+	// Candidates are the static candidates, in order of preference.
+	Candidates []Source
+	// Discover, if set, returns the candidates discovered while running,
+	// which are tried after the static ones.
+	Discover      func(ctx context.Context) ([]Source, error)
+	ProbeInterval time.Duration
+
+	// mu guards active and probed.
+	mu     sync.Mutex
+	active Source
+	probed time.Time
+}
+
+// NewFailoverSource returns a FailoverSource of the given candidates.
+func NewFailoverSource(candidates ...Source) *FailoverSource {
+	return &FailoverSource{
+		Candidates:    candidates,
+		ProbeInterval: DefaultProbeInterval,
+	}
+}
+
+// Refresh probes the candidates if the probe interval elapsed or the active
+// source failed, then refreshes the active source if needed. It is part of
+// the Refresher interface.
+func (f *FailoverSource) Refresh(ctx context.Context) error {
+	f.mu.Lock()
+	interval := f.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	due := f.active == nil || time.Since(f.probed) >= interval
+	f.mu.Unlock()
+
+	if due {
+		if err := f.probe(ctx); err != nil {
+			return err
+		}
+	}
+	if refresher, ok := f.current().(Refresher); ok {
+		return refresher.Refresh(ctx)
+	}
+	return nil
+}
+
+// probe checks all the candidates concurrently and activates the first
+// healthy one. The active source is kept if none is healthy.
+func (f *FailoverSource) probe(ctx context.Context) error {
+	candidates := append([]Source(nil), f.Candidates...)
+	if f.Discover != nil {
+		discovered, err := f.Discover(ctx)
+		if err != nil {
+			log.Debugf("Failed to discover data sources: %v", err)
+		}
+		candidates = append(candidates, discovered...)
+	}
+
+	errs := make([]error, len(candidates))
+	var wg sync.WaitGroup
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = candidates[i].Check(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probed = time.Now()
+	var failures []string
+	for i, candidate := range candidates {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", candidate, errs[i]))
+			continue
+		}
+		if f.active == nil || fmt.Sprint(f.active) != fmt.Sprint(candidate) {
+			log.Infof("Switching data source to %v", candidate)
+			f.active = candidate
+		}
+		return nil
+	}
+	if len(candidates) == 0 {
+		return errors.New("no data source candidates")
+	}
+	return fmt.Errorf("no healthy data source: %s", strings.Join(failures, "; "))
+}
+
+// current returns the active source, or the first candidate before any
+// successful probe.
+func (f *FailoverSource) current() Source {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active == nil && len(f.Candidates) > 0 {
+		return f.Candidates[0]
+	}
+	return f.active
+}
+
+// failed schedules a probe on the next refresh after a query failed.
+func (f *FailoverSource) failed(ctx context.Context, source Source, err error) {
+	if err == nil || err == ErrEmptyResult || ctx.Err() == context.Canceled {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active == source {
+		f.probed = time.Time{}
+	}
+}
+
+// Query is part of the Source interface.
+func (f *FailoverSource) Query(ctx context.Context, query string) (*Metrics, error) {
+	source := f.current()
+	if source == nil {
+		return nil, errors.New("no data source candidates")
+	}
+	metrics, err := source.Query(ctx, query)
+	f.failed(ctx, source, err)
+	return metrics, err
+}
+
+// QueryRange is part of the Source interface.
+func (f *FailoverSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error) {
+	source := f.current()
+	if source == nil {
+		return nil, errors.New("no data source candidates")
+	}
+	metrics, err := source.QueryRange(ctx, query, start, end, step)
+	f.failed(ctx, source, err)
+	return metrics, err
+}
+
+// Check probes the candidates and returns an error if none is healthy. It is
+// part of the Source interface.
+func (f *FailoverSource) Check(ctx context.Context) error {
+	return f.probe(ctx)
+}
+
+func (f *FailoverSource) String() string {
+	active := f.current()
+	if active == nil {
+		return "failover without candidates"
+	}
+	return fmt.Sprintf("failover across %d candidates, active %v", len(f.Candidates), active)
+}
+
+// ServiceDiscovery finds the data sources among the services selected by a
+// label selector, e.g. the prometheus or cortex services of the cluster.
+type ServiceDiscovery struct {
+	ClientSet kubernetes.Interface
+	Selector  string
+	// NewSource returns the source of the base URL of a discovered service.
+	NewSource func(baseURL string) Source
+	// APIError, if set, is called with the failed Kubernetes API calls.
+	APIError func(resource string, err error)
+}
+
+// Discover returns the sources of the selected services, ordered by namespace
+// and name. It can be used as the Discover function of a FailoverSource.
+func (d *ServiceDiscovery) Discover(ctx context.Context) ([]Source, error) {
+	serviceList, err := d.ClientSet.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: d.Selector,
+	})
+	if err != nil {
+		if d.APIError != nil {
+			d.APIError("services", err)
+		}
+		return nil, err
+	}
+
+	services := serviceList.Items
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+	var sources []Source
+	for _, service := range services {
+		if baseURL, ok := serviceURL(service); ok {
+			sources = append(sources, d.NewSource(baseURL))
+		}
+	}
+	return sources, nil
+}
+
+// serviceURL returns the base URL of the query API of a service, served on
+// the port named http, https or web, or else on its first port.
+func serviceURL(service corev1.Service) (string, bool) {
+	if len(service.Spec.Ports) == 0 {
+		return "", false
+	}
+	port := service.Spec.Ports[0]
+	for _, p := range service.Spec.Ports {
+		if p.Name == "http" || p.Name == "https" || p.Name == "web" {
+			port = p
+			break
+		}
+	}
+	scheme := "http"
+	if port.Name == "https" || port.Port == 443 {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s.%s.svc:%d", scheme, service.Name, service.Namespace, port.Port), true
+}
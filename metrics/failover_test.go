@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// stubSource is a Source which is healthy unless err is set.
+type stubSource struct {
+	name string
+	err  error
+}
+
+func (s *stubSource) Query(ctx context.Context, query string) (*Metrics, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return queryResponse("vector", []Result{{Metric: Metric{"openebs_pv": s.name}}})
+}
+
+func (s *stubSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error) {
+	return s.Query(ctx, query)
+}
+
+func (s *stubSource) Check(ctx context.Context) error {
+	return s.err
+}
+
+func (s *stubSource) String() string {
+	return s.name
+}
+
+func TestFailoverSource(t *testing.T) {
+	primary := &stubSource{name: "primary", err: errors.New("connection refused")}
+	secondary := &stubSource{name: "secondary"}
+	f := NewFailoverSource(primary, secondary)
+	ctx := context.Background()
+
+	queried := func() string {
+		got, err := f.Query(ctx, "vector(1)")
+		if err != nil {
+			return err.Error()
+		}
+		return got.Data.Result[0].Metric["openebs_pv"]
+	}
+
+	if err := f.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := queried(); got != "secondary" {
+		t.Errorf("Query() with the primary down used %v, want secondary", got)
+	}
+
+	// The recovered primary is used again after the next probe only.
+	primary.err = nil
+	if err := f.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := queried(); got != "secondary" {
+		t.Errorf("Query() before the probe interval used %v, want secondary", got)
+	}
+	f.ProbeInterval = time.Nanosecond
+	if err := f.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := queried(); got != "primary" {
+		t.Errorf("Query() after the probe used %v, want primary", got)
+	}
+
+	// A failed query triggers a probe on the next refresh.
+	f.ProbeInterval = time.Hour
+	primary.err = errors.New("connection reset")
+	if got := queried(); got != "connection reset" {
+		t.Errorf("Query() = %v, want the error of the primary", got)
+	}
+	if err := f.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := queried(); got != "secondary" {
+		t.Errorf("Query() after a failure used %v, want secondary", got)
+	}
+
+	secondary.err = errors.New("timeout")
+	if err := f.Check(ctx); err == nil {
+		t.Error("Check() error = nil, want an error when all the candidates are down")
+	}
+}
+
+func TestServiceDiscovery(t *testing.T) {
+	service := func(namespace, name string, ports ...corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    map[string]string{"openebs.io/data-source": "true"},
+			},
+			Spec: corev1.ServiceSpec{Ports: ports},
+		}
+	}
+	clientSet := fake.NewSimpleClientset(
+		service("monitoring", "prometheus", corev1.ServicePort{Name: "metrics", Port: 8080}, corev1.ServicePort{Name: "web", Port: 9090}),
+		service("cortex", "query-frontend", corev1.ServicePort{Name: "https", Port: 8443}),
+		service("monitoring", "headless"),
+	)
+	d := &ServiceDiscovery{
+		ClientSet: clientSet,
+		Selector:  "openebs.io/data-source=true",
+		NewSource: func(baseURL string) Source {
+			return NewPrometheusSource(baseURL)
+		},
+	}
+
+	sources, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	var got []string
+	for _, source := range sources {
+		got = append(got, source.(*PrometheusSource).URL)
+	}
+	want := []string{
+		"https://query-frontend.cortex.svc:8443",
+		"http://prometheus.monitoring.svc:9090",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Discover() = %v, want %v", got, want)
+	}
+
+	var failed []string
+	d.APIError = func(resource string, err error) {
+		failed = append(failed, resource)
+	}
+	clientSet.PrependReactor("list", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("services is forbidden")
+	})
+	if _, err := d.Discover(context.Background()); err == nil {
+		t.Fatal("Discover() error = nil, want the list error")
+	}
+	if want := []string{"services"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Discover() API errors = %v, want %v", failed, want)
+	}
+}
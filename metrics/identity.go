@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+)
+
+// IdentityRule extracts the name of the PV, or of the cStor pool, of a
+// series from its labels. The labels are tried in order and the first one
+// which is set and matches Regex gives the name: the first group of the
+// regex, or the whole match if it has no group. An empty regex matches any
+// value.
+type IdentityRule struct {
+	Labels []string `json:"labels"`
+	Regex  string   `json:"regex,omitempty"`
+	// ClaimNamespaceLabel, if set, means that the name is the one of a PVC
+	// in the namespace of this label, such as in the kubelet volume stats,
+	// and the series is matched to the PV bound to it.
+	ClaimNamespaceLabel string `json:"claimNamespaceLabel,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// IdentityRules are tried in order to match the series to PVs and cStor
+// pools, e.g.
+//
+//   - labels: [openebs_pv, persistentvolume]
+//   - labels: [volume]
+//     regex: '^(pvc-[0-9a-f-]+)'
+//
+// The series which match no rule are dropped.
+type IdentityRules []IdentityRule
+
+// DefaultIdentityRules returns the labels of the OpenEBS exporters, the CSI
+// drivers, the kubelet volume stats and the cStor pools.
+func DefaultIdentityRules() IdentityRules {
+	return IdentityRules{
+		{Labels: []string{"openebs_pv", "persistentvolume", "pv_name"}},
+		// The volume label is generic, e.g. the name of a pod volume, so it
+		// only names the dynamically provisioned PVs.
+		{Labels: []string{"volume"}, Regex: `^pvc-[0-9a-f-]+$`},
+		{Labels: []string{"persistentvolumeclaim"}, ClaimNamespaceLabel: "namespace"},
+		{Labels: []string{"cstor_pool"}},
+	}
+}
+
+// LoadIdentityRules reads YAML or JSON identity rules from the given file.
+func LoadIdentityRules(path string) (IdentityRules, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules IdentityRules
+	if err := yaml.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse identity rules %q: %v", path, err)
+	}
+
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid identity rules %q: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Validate checks that the rules are usable and compiles their regexes.
+func (r IdentityRules) Validate() error {
+	if len(r) == 0 {
+		return fmt.Errorf("no rules defined")
+	}
+
+	for i := range r {
+		if len(r[i].Labels) == 0 {
+			return fmt.Errorf("rule %d: labels are required", i)
+		}
+		if r[i].Regex == "" {
+			continue
+		}
+		regex, err := regexp.Compile(r[i].Regex)
+		if err != nil {
+			return fmt.Errorf("rule %d: %v", i, err)
+		}
+		r[i].regex = regex
+	}
+	return nil
+}
+
+// identity returns the name given by the first matching rule, and false if
+// none matches. claims maps the PVCs, as "namespace/name", to the name of
+// their PV.
+func (r IdentityRules) identity(labels Metric, claims map[string]string) (string, bool) {
+	for _, rule := range r {
+		name, ok := rule.identity(labels)
+		if ok && rule.ClaimNamespaceLabel != "" {
+			name, ok = claims[labels[rule.ClaimNamespaceLabel]+"/"+name]
+		}
+		if ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (rule IdentityRule) identity(labels Metric) (string, bool) {
+	regex := rule.regex
+	if regex == nil && rule.Regex != "" {
+		var err error
+		if regex, err = regexp.Compile(rule.Regex); err != nil {
+			return "", false
+		}
+	}
+
+	for _, label := range rule.Labels {
+		value := labels[label]
+		if value == "" {
+			continue
+		}
+		if regex == nil {
+			return value, true
+		}
+		match := regex.FindStringSubmatch(value)
+		switch {
+		case match == nil:
+			continue
+		case len(match) > 1 && match[1] != "":
+			return match[1], true
+		case len(match) == 1 && match[0] != "":
+			return match[0], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityRules(t *testing.T) {
+	rules := IdentityRules{
+		{Labels: []string{"openebs_pv", "persistentvolume"}},
+		{Labels: []string{"target"}, Regex: `^(pvc-[0-9a-f-]+)-ctrl`},
+		{Labels: []string{"volume"}, Regex: `pvc-[0-9]+`},
+		{Labels: []string{"persistentvolumeclaim"}, ClaimNamespaceLabel: "namespace"},
+	}
+	claims := map[string]string{
+		"default/data": "pvc-7",
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		labels Metric
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "when the first label is set",
+			labels: Metric{"openebs_pv": "pvc-1", "persistentvolume": "pvc-2"},
+			want:   "pvc-1",
+			wantOk: true,
+		},
+		{
+			name:   "when only the fallback label is set",
+			labels: Metric{"persistentvolume": "pvc-2"},
+			want:   "pvc-2",
+			wantOk: true,
+		},
+		{
+			name:   "when the regex has a group",
+			labels: Metric{"target": "pvc-ab12-ctrl-7f9c"},
+			want:   "pvc-ab12",
+			wantOk: true,
+		},
+		{
+			name:   "when the regex has no group",
+			labels: Metric{"volume": "data-pvc-42"},
+			want:   "pvc-42",
+			wantOk: true,
+		},
+		{
+			name:   "when the regex does not match",
+			labels: Metric{"target": "cstor-pool-1"},
+			wantOk: false,
+		},
+		{
+			name:   "when the claim is bound",
+			labels: Metric{"persistentvolumeclaim": "data", "namespace": "default"},
+			want:   "pvc-7",
+			wantOk: true,
+		},
+		{
+			name:   "when the claim is in another namespace",
+			labels: Metric{"persistentvolumeclaim": "data", "namespace": "test"},
+			wantOk: false,
+		},
+		{
+			name:   "when no label is set",
+			labels: Metric{"instance": "10.16.1.4:9500"},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rules.identity(tt.labels, claims)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("identity() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestDefaultIdentityRules(t *testing.T) {
+	rules := DefaultIdentityRules()
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		labels Metric
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "when the volume is a PV",
+			labels: Metric{"volume": "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a"},
+			want:   "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a",
+			wantOk: true,
+		},
+		{
+			name:   "when the volume is a pod volume",
+			labels: Metric{"volume": "data"},
+			wantOk: false,
+		},
+		{
+			name:   "when the PV label is set",
+			labels: Metric{"pv_name": "data", "volume": "config"},
+			want:   "data",
+			wantOk: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rules.identity(tt.labels, nil)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("identity() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestLoadIdentityRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "when rules are valid",
+			content: "- labels: [pv_name]\n- labels: [volume]\n  regex: '^(pvc-.*)$'\n",
+		},
+		{
+			name:    "when a rule has no labels",
+			content: "- regex: 'pvc'\n",
+			wantErr: true,
+		},
+		{
+			name:    "when a regex is invalid",
+			content: "- labels: [volume]\n  regex: '(pvc'\n",
+			wantErr: true,
+		},
+		{
+			name:    "when there are no rules",
+			content: "[]\n",
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("rules%d.yaml", i))
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadIdentityRules(path); (err != nil) != tt.wantErr {
+				t.Errorf("LoadIdentityRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
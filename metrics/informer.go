@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ResyncPeriod is the resync period of the informers.
+var ResyncPeriod = 10 * time.Minute
+
+// CacheSyncTimeout bounds the initial sync of the informer caches, which does
+// not complete when the API server is unreachable or denies the list calls.
+var CacheSyncTimeout = time.Minute
+
+// SyncDelay is the delay between a watch event and the update of the PV
+// lists, during which further events are coalesced into the same update.
+var SyncDelay = time.Second
+
+// listers read the PVs, pods and StorageClasses from the informer caches.
+type listers struct {
+	pv  corelisters.PersistentVolumeLister
+	pod corelisters.PodLister
+	sc  storagelisters.StorageClassLister
+	// The cStor custom resources are nil in clusters where they are not
+	// defined.
+	cstorVolume       cache.Store
+	cstorReplica      cache.Store
+	cstorPool         cache.Store
+	cstorPoolInstance cache.Store
+}
+
+// StartInformers starts watching PVs, pods and StorageClasses, and the
+// cStor custom resources if the dynamic client is set, and keeps the PV lists
+// of p up to date with the watch events. It returns once the caches are
+// synced, after which GetPVList, GetCStorVolumeList and GetCStorPoolList no
+// longer call the API server, or with an error if they are not synced within
+// CacheSyncTimeout.
+func (p *PVMetrics) StartInformers(stopCh <-chan struct{}) error {
+	client := p.ClientSet
+	pvInformer := newInformer("persistentvolumes", &corev1.PersistentVolume{}, p.stats.apiError,
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().PersistentVolumes().List(options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().PersistentVolumes().Watch(options)
+		})
+	podInformer := newInformer("pods", &corev1.Pod{}, p.stats.apiError,
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Pods(p.PodNamespace).List(options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Pods(p.PodNamespace).Watch(options)
+		})
+	scInformer := newInformer("storageclasses", &storagev1.StorageClass{}, p.stats.apiError,
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.StorageV1().StorageClasses().List(options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.StorageV1().StorageClasses().Watch(options)
+		})
+
+	var cstorVolumeInformer, cstorReplicaInformer, cstorPoolInformer, cstorPoolInstanceInformer cache.SharedIndexInformer
+	if p.DynamicClient != nil {
+		cstorVolumeInformer = p.newDynamicInformer(CStorVolumeResource)
+		cstorReplicaInformer = p.newDynamicInformer(CStorVolumeReplicaResource)
+		cstorPoolInformer = p.newDynamicInformer(CStorPoolResource)
+		cstorPoolInstanceInformer = p.newDynamicInformer(CStorPoolInstanceResource)
+	}
+
+	informers := []cache.SharedIndexInformer{pvInformer, podInformer, scInformer}
+	for _, informer := range []cache.SharedIndexInformer{cstorVolumeInformer, cstorReplicaInformer, cstorPoolInformer, cstorPoolInstanceInformer} {
+		if informer != nil {
+			informers = append(informers, informer)
+		}
+	}
+	stop, err := runInformers(stopCh, informers)
+	if err != nil {
+		return err
+	}
+
+	p.listers = &listers{
+		pv:  corelisters.NewPersistentVolumeLister(pvInformer.GetIndexer()),
+		pod: corelisters.NewPodLister(podInformer.GetIndexer()),
+		sc:  storagelisters.NewStorageClassLister(scInformer.GetIndexer()),
+
+		cstorVolume:       informerStore(cstorVolumeInformer),
+		cstorReplica:      informerStore(cstorReplicaInformer),
+		cstorPool:         informerStore(cstorPoolInformer),
+		cstorPoolInstance: informerStore(cstorPoolInstanceInformer),
+	}
+	p.syncLists()
+
+	// pending holds a value while an update of the PV lists is due, so that
+	// a burst of events, such as the pods of a rollout, updates them once.
+	pending := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case pending <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	}
+	for _, informer := range informers {
+		informer.AddEventHandler(handler)
+	}
+	go p.syncLoop(pending, stop)
+	return nil
+}
+
+// StartInformer starts watching the exporter pods selected by s. It returns
+// once the cache is synced, after which Refresh reads the pods from the cache
+// instead of listing them on every refresh, or with an error if it is not
+// synced within CacheSyncTimeout.
+func (s *Scraper) StartInformer(stopCh <-chan struct{}) error {
+	client, selector := s.ClientSet, s.Selector
+	podInformer := newInformer("pods", &corev1.Pod{}, s.apiError,
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return client.CoreV1().Pods(metav1.NamespaceAll).List(options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return client.CoreV1().Pods(metav1.NamespaceAll).Watch(options)
+		})
+	if _, err := runInformers(stopCh, []cache.SharedIndexInformer{podInformer}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.pods = corelisters.NewPodLister(podInformer.GetIndexer())
+	s.mu.Unlock()
+	return nil
+}
+
+// syncLoop updates the PV lists SyncDelay after each notification on
+// pending, until stop is closed.
+func (p *PVMetrics) syncLoop(pending <-chan struct{}, stop <-chan struct{}) {
+	for {
+		select {
+		case <-pending:
+		case <-stop:
+			return
+		}
+		select {
+		case <-time.After(SyncDelay):
+		case <-stop:
+			return
+		}
+		// The events received during the delay are covered by this update.
+		select {
+		case <-pending:
+		default:
+		}
+		p.syncLists()
+	}
+}
+
+// syncLists updates the PV lists and the cStor resources from the informer
+// caches.
+func (p *PVMetrics) syncLists() {
+	p.syncPVList()
+	p.syncCStorVolumes()
+	p.syncCStorPools()
+}
+
+// runInformers runs informers until stopCh is closed, and returns once their
+// caches are synced. It returns the channel which stops the informers, or an
+// error, after stopping them, if the caches are not synced within
+// CacheSyncTimeout.
+func runInformers(stopCh <-chan struct{}, informers []cache.SharedIndexInformer) (<-chan struct{}, error) {
+	// The informers run until stopCh is closed, or until the deadline if
+	// their caches are not synced by then.
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopInformers := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+	go func() {
+		select {
+		case <-stopCh:
+			stopInformers()
+		case <-stop:
+		}
+	}()
+
+	hasSynced := make([]cache.InformerSynced, 0, len(informers))
+	for _, informer := range informers {
+		go informer.Run(stop)
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	deadline := time.AfterFunc(CacheSyncTimeout, stopInformers)
+	synced := cache.WaitForCacheSync(stop, hasSynced...)
+	if !deadline.Stop() || !synced {
+		stopInformers()
+		return nil, fmt.Errorf("failed to sync informer caches within %v", CacheSyncTimeout)
+	}
+	return stop, nil
+}
+
+// newInformer returns a shared informer for the objects returned by listFunc
+// and watchFunc, whose failed calls are passed to apiError with resource.
+func newInformer(resource string, objType runtime.Object, apiError func(resource string, err error), listFunc cache.ListFunc, watchFunc cache.WatchFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				obj, err := listFunc(options)
+				apiError(resource, err)
+				return obj, err
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				w, err := watchFunc(options)
+				apiError(resource, err)
+				return w, err
+			},
+		},
+		objType,
+		ResyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
+
+// newDynamicInformer returns an informer of the custom resources, or nil if
+// they are not defined in the cluster. They are then never listed again, so
+// that clusters without cStor do not get a failed call on every refresh.
+func (p *PVMetrics) newDynamicInformer(resource schema.GroupVersionResource) cache.SharedIndexInformer {
+	client := p.DynamicClient.Resource(resource).Namespace(metav1.NamespaceAll)
+	_, err := client.List(metav1.ListOptions{Limit: 1})
+	if apierrors.IsNotFound(err) {
+		log.Infof("%s are not defined in the cluster, they are not watched", resource.Resource)
+		return nil
+	}
+	p.stats.apiError(resource.Resource, err)
+	return newInformer(resource.Resource, &unstructured.Unstructured{}, p.stats.apiError,
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(options)
+		})
+}
+
+// informerStore returns the cache of informer, nil if informer is nil.
+func informerStore(informer cache.SharedIndexInformer) cache.Store {
+	if informer == nil {
+		return nil
+	}
+	return informer.GetStore()
+}
+
+// unstructuredItems returns the custom resources of store, none if it is nil.
+func unstructuredItems(store cache.Store) []unstructured.Unstructured {
+	if store == nil {
+		return nil
+	}
+	objects := store.List()
+	items := make([]unstructured.Unstructured, 0, len(objects))
+	for _, obj := range objects {
+		if item, ok := obj.(*unstructured.Unstructured); ok {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+// syncPVList updates the PV lists from the informer caches.
+func (p *PVMetrics) syncPVList() {
+	pvs, err := p.listers.pv.List(labels.Everything())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	pvListItems := make([]corev1.PersistentVolume, 0, len(pvs))
+	for _, pv := range pvs {
+		pvListItems = append(pvListItems, *pv)
+	}
+
+	pods, err := p.listers.pod.List(labels.Everything())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	podListItems := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		podListItems = append(podListItems, *pod)
+	}
+
+	scs, err := p.listers.sc.List(labels.Everything())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	scListItems := make([]storagev1.StorageClass, 0, len(scs))
+	for _, sc := range scs {
+		scListItems = append(scListItems, *sc)
+	}
+
+	p.update(func(s *Snapshot) {
+		s.PVList = p.PVNameAndUID(pvListItems)
+		s.PVCList = p.PVNameAndClaimUID(pvListItems)
+		s.PodList = p.PVNameAndPodUIDs(pvListItems, podListItems)
+		s.Details = p.PVNameAndDetails(pvListItems, podListItems)
+		s.SCList = p.PVNameAndSCUID(pvListItems, scListItems)
+		s.Expandable = p.PVNameAndExpandable(pvListItems, scListItems)
+	})
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPVMetrics_StartInformers(t *testing.T) {
+	allowExpansion := true
+	clientSet := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV",
+				UID:  "pv1234",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName: "openebs-cstor",
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: "default",
+					Name:      "testPVC",
+					UID:       "pvc1234",
+				},
+			},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "testPVC",
+				UID:       "pvc1234",
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeName: "testPV",
+			},
+		},
+		// A claim binding to a PV which does not reference it yet is not
+		// reported, as on the polling path.
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "bindingPVC",
+				UID:       "pvc5678",
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeName: "bindingPV",
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "testPod",
+				UID:       "pod1234",
+			},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+								ClaimName: "testPVC",
+							},
+						},
+					},
+				},
+			},
+		},
+		&storagev1.StorageClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "openebs-cstor",
+				UID:  "sc1234",
+			},
+			AllowVolumeExpansion: &allowExpansion,
+		},
+	)
+	p := &PVMetrics{
+		ClientSet: clientSet,
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := p.StartInformers(stopCh); err != nil {
+		t.Fatalf("PVMetrics.StartInformers() error = %v", err)
+	}
+	s := p.Snapshot()
+	if want := map[string]string{"testPV": "pv1234"}; !reflect.DeepEqual(s.PVList, want) {
+		t.Errorf("PVMetrics.PVList = %v, want %v", s.PVList, want)
+	}
+	if want := map[string]string{"testPV": "pvc1234"}; !reflect.DeepEqual(s.PVCList, want) {
+		t.Errorf("PVMetrics.PVCList = %v, want %v", s.PVCList, want)
+	}
+	if want := map[string][]string{"testPV": {"pod1234"}}; !reflect.DeepEqual(s.PodList, want) {
+		t.Errorf("PVMetrics.PodList = %v, want %v", s.PodList, want)
+	}
+	if want := map[string]string{"testPV": "sc1234"}; !reflect.DeepEqual(s.SCList, want) {
+		t.Errorf("PVMetrics.SCList = %v, want %v", s.SCList, want)
+	}
+	if want := map[string]bool{"testPV": true}; !reflect.DeepEqual(s.Expandable, want) {
+		t.Errorf("PVMetrics.Expandable = %v, want %v", s.Expandable, want)
+	}
+
+	// The lists are updated from watch events.
+	_, err := clientSet.CoreV1().PersistentVolumes().Create(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "testPV1",
+			UID:  "pv5678",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"testPV": "pv1234", "testPV1": "pv5678"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := p.Snapshot().PVList
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("PVMetrics.PVList = %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// GetPVList leaves the lists to the informers once they are started.
+	actions := len(clientSet.Actions())
+	p.GetPVList()
+	if got := len(clientSet.Actions()); got != actions {
+		t.Errorf("PVMetrics.GetPVList() made %d API calls, want 0", got-actions)
+	}
+}
+
+func TestPVMetrics_StartInformersTimeout(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("pods is forbidden")
+	})
+	p := &PVMetrics{
+		ClientSet: clientSet,
+	}
+	defer func(timeout time.Duration) { CacheSyncTimeout = timeout }(CacheSyncTimeout)
+	CacheSyncTimeout = 200 * time.Millisecond
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := p.StartInformers(stopCh); err == nil {
+		t.Fatal("PVMetrics.StartInformers() error = nil, want an error when the caches do not sync")
+	}
+	if p.listers != nil {
+		t.Error("PVMetrics.StartInformers() set the listers after failing")
+	}
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	if p.stats.apiErrors["pods"] == 0 {
+		t.Errorf("PVMetrics.StartInformers() API errors = %v, want the failed pod lists", p.stats.apiErrors)
+	}
+}
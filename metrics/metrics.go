@@ -1,133 +1,436 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
-	"github.com/openebs/scope-plugin/k8s"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
-// URL is the address of cortex agent.
-var (
-	URL = "http://localhost:80/api/v1/query?query="
+const (
+	// DefaultRangeWindow is the default duration of history fetched for each metric.
+	DefaultRangeWindow = 10 * time.Minute
+	// DefaultRangeStep is the default resolution of the fetched history.
+	DefaultRangeStep = 15 * time.Second
+	// DefaultQueryTimeout is the default timeout of a single query.
+	DefaultQueryTimeout = 10 * time.Second
+	// DefaultWorkers is the default number of queries run concurrently.
+	DefaultWorkers = 4
 )
 
-var Count int = 0
-
-// Mutex is used to lock over metrics structure.
-var Mutex = &sync.Mutex{}
-
-// NewMetrics will return an object of PVMetrics struct initialized with the queries.
-func NewMetrics() PVMetrics {
-	return PVMetrics{
-		Queries: map[string]string{
-			"iopsReadQuery":        "irate(openebs_reads[5m])",
-			"iopsWriteQuery":       "irate(openebs_writes[5m])",
-			"latencyReadQuery":     "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000",
-			"latencyWriteQuery":    "((irate(openebs_write_time[5m]))/(irate(openebs_writes[5m])))/1000000",
-			"throughputReadQuery":  "irate(openebs_read_block_count[5m])/(2048)",
-			"throughputWriteQuery": "irate(openebs_write_block_count[5m])/(2048)",
+// ErrEmptyResult is returned by the queries which matched no series. It is
+// not a failure of the query: an idle volume has, for instance, no latency.
+var ErrEmptyResult = errors.New("Result is empty")
+
+// DefaultHTTPClient is the client shared by the sources which have no
+// HTTPClient of their own.
+var DefaultHTTPClient = &http.Client{}
+
+// NewMetrics will return an object of PVMetrics struct initialized with the
+// queries of the given catalog.
+func NewMetrics(catalog Catalog, clientSet kubernetes.Interface) *PVMetrics {
+	return &PVMetrics{
+		Catalog:      catalog,
+		Queries:      catalog.Queries(),
+		Schedule:     DefaultSchedule(),
+		QueryTimeout: DefaultQueryTimeout,
+		Workers:      DefaultWorkers,
+		Range: RangeQuery{
+			Window: DefaultRangeWindow,
+			Step:   DefaultRangeStep,
 		},
-		PVList:    nil,
-		Data:      nil,
-		ClientSet: k8s.NewClientSet(),
+		ClientSet: clientSet,
 	}
 }
 
-// UpdateMetrics will update the metrics data and PV list
-func (p *PVMetrics) UpdateMetrics() {
-	for {
-		p.UpdatePVMetrics()
-		// time.Sleep(2 * time.Second)
+// queryResult is the outcome of a single query.
+type queryResult struct {
+	name    string
+	values  map[string]float64
+	samples map[string][]sample
+	latency time.Duration
+	err     error
+}
+
+// UpdatePVMetrics will update the PVMetrics struct object with the required data.
+// The queries are run concurrently by at most Workers goroutines and their
+// results are stored independently: a failed query keeps its previous data,
+// which is then reported as stale, without affecting the other queries.
+// It returns an error only if all the queries failed.
+func (p *PVMetrics) UpdatePVMetrics(ctx context.Context) error {
+	if refresher, ok := p.source().(Refresher); ok {
+		// A failed refresh is reported by the queries.
+		refreshCtx, cancel := context.WithTimeout(ctx, p.queryTimeout())
+		if err := refresher.Refresh(refreshCtx); err != nil {
+			log.Debugf("Failed to refresh the data source: %v", err)
+		}
+		cancel()
+	}
+	results := p.runQueries(ctx)
+	p.stats.observeQueries(results)
+	now := time.Now()
+
+	var updateErr error
+	failures := 0
+	for _, result := range results {
+		if result.err != nil {
+			failures++
+			log.Debugf("Failed to fetch metrics for %s", result.name)
+			updateErr = fmt.Errorf("failed to fetch metrics for %s: %v", result.name, result.err)
+		}
+	}
+	if len(results) > 0 && failures == len(results) {
+		updateErr = fmt.Errorf("all %d queries failed, last error: %v", failures, updateErr)
+	} else {
+		updateErr = nil
 	}
+
+	p.update(func(s *Snapshot) {
+		p.logErrors(results)
+		s.Status = updateStatus(s.Status, results, now)
+		if updateErr == nil {
+			s.merge(p.Queries, results, p.Range.Window > 0)
+			s.Time = now
+		}
+	})
+
+	p.GetPVList()
+	p.GetCStorVolumeList()
+	p.GetCStorPoolList()
+	if updateErr == nil {
+		p.evaluateAlerts(now)
+	}
+	return updateErr
 }
 
-// UpdatePVMetrics will update the PVMetrics struct object with the required data
-func (p *PVMetrics) UpdatePVMetrics() {
-	data := make(map[string]map[string]float64)
-	for queryName, query := range p.Queries {
-		pvMetricsvalue, err := p.GetMetrics(query)
-		if err != nil {
-			if Count < 5 {
-				log.Error(err)
-				Count = Count + 1
-			}
+// logErrors logs the errors of the queries, at most 5 times until a refresh
+// succeeds so that an unavailable data source does not flood the logs.
+// It must be called with mu held.
+func (p *PVMetrics) logErrors(results []queryResult) {
+	failed := false
+	for _, result := range results {
+		if result.err == nil {
+			continue
 		}
+		failed = true
+		if p.errorLogs < 5 {
+			log.Error(result.err)
+			p.errorLogs++
+		}
+	}
+	if !failed {
+		p.errorLogs = 0
+	}
+}
 
-		if pvMetricsvalue == nil {
-			data = nil
-			log.Debugf("Failed to fetch metrics for %s", queryName)
-			break
+// updateStatus returns a copy of status updated with the given results run at now.
+func updateStatus(status map[string]QueryStatus, results []queryResult, now time.Time) map[string]QueryStatus {
+	updated := make(map[string]QueryStatus, len(results))
+	for queryName, queryStatus := range status {
+		updated[queryName] = queryStatus
+	}
+	for _, result := range results {
+		queryStatus := updated[result.name]
+		queryStatus.Checked = now
+		queryStatus.Err = result.err
+		if result.err == nil {
+			queryStatus.Updated = now
 		}
-		data[queryName] = pvMetricsvalue
+		updated[result.name] = queryStatus
 	}
+	return updated
+}
 
-	if data != nil {
-		Mutex.Lock()
-		p.Data = data
-		Mutex.Unlock()
-		Count = 0
+// runQueries runs all the queries with a bounded pool of workers.
+func (p *PVMetrics) runQueries(ctx context.Context) []queryResult {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(p.Queries) {
+		workers = len(p.Queries)
 	}
 
-	p.GetPVList()
+	jobs := make(chan string)
+	results := make(chan queryResult, len(p.Queries))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for queryName := range jobs {
+				results <- p.runQuery(ctx, queryName, p.Queries[queryName])
+			}
+		}()
+	}
+
+	for queryName := range p.Queries {
+		jobs <- queryName
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	queryResults := make([]queryResult, 0, len(p.Queries))
+	for result := range results {
+		queryResults = append(queryResults, result)
+	}
+	return queryResults
 }
 
-// GetMetrics will return the metrics for the given query.
-func (p *PVMetrics) GetMetrics(query string) (map[string]float64, error) {
-	response, err := http.Get(URL + query)
-	if err != nil {
-		return nil, err
+// runQuery runs a single query and records its latency. The volumes missing
+// from its results are filled from its fallback query, if any, which is also
+// used when the query fails.
+func (p *PVMetrics) runQuery(ctx context.Context, queryName, query string) queryResult {
+	result := queryResult{
+		name: queryName,
 	}
+	start := time.Now()
+	// The pool queries are only shown as current values, so their history
+	// is not fetched.
+	withHistory := p.Range.Window > 0 && !p.poolCatalog().has(queryName)
+	result.values, result.samples, result.err = p.fetch(ctx, query, withHistory)
+	if fallback := p.queryCatalog().fallback(queryName); fallback != "" {
+		values, samples, err := p.fetch(ctx, fallback, withHistory)
+		switch {
+		case err != nil && result.err != nil:
+			log.Debugf("Failed to fetch the fallback of %s: %v", queryName, err)
+		case err != nil:
+		case result.err != nil:
+			result.values, result.samples, result.err = values, samples, nil
+		default:
+			for name, value := range values {
+				if _, ok := result.values[name]; !ok {
+					result.values[name] = value
+				}
+			}
+			for name, s := range samples {
+				if _, ok := result.samples[name]; !ok {
+					result.samples[name] = s
+				}
+			}
+		}
+	}
+	result.latency = time.Since(start)
+	return result
+}
 
-	responseBody, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+// fetch runs an instant query, or a range query if withHistory is set.
+// An empty result is not an error.
+func (p *PVMetrics) fetch(ctx context.Context, query string, withHistory bool) (map[string]float64, map[string][]sample, error) {
+	var values map[string]float64
+	var samples map[string][]sample
+	var err error
+	if withHistory {
+		samples, err = p.GetRangeMetrics(ctx, query)
+		if samples != nil {
+			values = latestValues(samples)
+		}
+	} else {
+		values, err = p.GetMetrics(ctx, query)
+	}
+	if err == ErrEmptyResult {
+		values, err = map[string]float64{}, nil
+		if withHistory {
+			samples = map[string][]sample{}
+		}
 	}
+	return values, samples, err
+}
 
-	pvMetrics, err := p.UnmarshalResponse([]byte(responseBody))
+// GetMetrics will return the metrics for the given query, keyed by PV name
+// or by cStor pool name for pool queries.
+func (p *PVMetrics) GetMetrics(ctx context.Context, query string) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.queryTimeout())
+	defer cancel()
+	pvMetrics, err := p.source().Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pvMetrics.Data.Result) == 0 {
-		return nil, errors.New("Result is empty")
+	identity, claims := p.identity(), p.claimVolumes()
+	pvMetricsValue := make(map[string]float64)
+	for _, pvMetric := range pvMetrics.Data.Result {
+		name, ok := identity.identity(pvMetric.Metric, claims)
+		if !ok {
+			continue
+		}
+		pvMetricsValue[name] = parseValue(pvMetric.Value[1])
 	}
 
-	pvMetricsValue := make(map[string]float64)
+	return pvMetricsValue, nil
+}
+
+// GetRangeMetrics will return the samples of the given query over the
+// configured range window, keyed by PV name or cStor pool name.
+func (p *PVMetrics) GetRangeMetrics(ctx context.Context, query string) (map[string][]sample, error) {
+	end := time.Now()
+	start := end.Add(-p.Range.Window)
+	step := p.Range.Step
+	if step <= 0 {
+		step = DefaultRangeStep
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.queryTimeout())
+	defer cancel()
+	pvMetrics, err := p.source().QueryRange(ctx, query, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, claims := p.identity(), p.claimVolumes()
+	pvMetricsSamples := make(map[string][]sample)
 	for _, pvMetric := range pvMetrics.Data.Result {
-		// For handling https://github.com/cortexproject/cortex/blob/1f75367734bd3fd7d106beea86f9901fd1e99750/vendor/github.com/prometheus/prometheus/promql/quantile.go#L64
-		if pvMetric.Value[1].(string) == "NaN" || pvMetric.Value[1].(string) == "+Inf" || pvMetric.Value[1].(string) == "-Inf" {
-			pvMetricsValue[pvMetric.Metric.OpenebsPv] = 0
-		} else {
-			metric, err := strconv.ParseFloat(pvMetric.Value[1].(string), 64)
-			if err != nil {
-				log.Error(err)
-				pvMetricsValue[pvMetric.Metric.OpenebsPv] = 0
-			} else {
-				pvMetricsValue[pvMetric.Metric.OpenebsPv] = metric
+		name, ok := identity.identity(pvMetric.Metric, claims)
+		if !ok {
+			continue
+		}
+		samples := make([]sample, 0, len(pvMetric.Values))
+		for _, value := range pvMetric.Values {
+			if len(value) < 2 {
+				continue
+			}
+			timestamp, ok := value[0].(float64)
+			if !ok {
+				continue
 			}
+			sec, frac := math.Modf(timestamp)
+			samples = append(samples, sample{
+				Date:  time.Unix(int64(sec), int64(frac*1e9)),
+				Value: parseValue(value[1]),
+			})
 		}
+		pvMetricsSamples[name] = samples
 	}
 
-	return pvMetricsValue, nil
+	return pvMetricsSamples, nil
+}
+
+// identity returns the identity rules of the results.
+func (p *PVMetrics) identity() IdentityRules {
+	if p.Identity == nil {
+		return DefaultIdentityRules()
+	}
+	return p.Identity
+}
+
+// claimVolumes returns the name of the PV bound to each PVC, keyed by
+// "namespace/name" of the PVC.
+func (p *PVMetrics) claimVolumes() map[string]string {
+	claims := make(map[string]string)
+	for pvName, details := range p.Snapshot().Details {
+		if details.Claim != "" {
+			claims[details.ClaimNamespace+"/"+details.Claim] = pvName
+		}
+	}
+	return claims
+}
+
+// queryTimeout returns the timeout of a single query.
+func (p *PVMetrics) queryTimeout() time.Duration {
+	if p.QueryTimeout <= 0 {
+		return DefaultQueryTimeout
+	}
+	return p.QueryTimeout
+}
+
+// source returns the data source of the metrics, defaulting to the
+// prometheus sidecar.
+func (p *PVMetrics) source() Source {
+	if p.Source == nil {
+		return NewPrometheusSource(DefaultSourceURL)
+	}
+	return p.Source
+}
+
+// parseValue converts a prometheus sample value into float64.
+// For handling https://github.com/cortexproject/cortex/blob/1f75367734bd3fd7d106beea86f9901fd1e99750/vendor/github.com/prometheus/prometheus/promql/quantile.go#L64
+// NaN and Inf values are reported as 0.
+func parseValue(value interface{}) float64 {
+	str, ok := value.(string)
+	if !ok || str == "NaN" || str == "+Inf" || str == "-Inf" {
+		return 0
+	}
+
+	metric, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+	return metric
+}
+
+// latestValues returns the value of the most recent sample for each PV.
+func latestValues(pvMetricsSamples map[string][]sample) map[string]float64 {
+	pvMetricsValue := make(map[string]float64)
+	for pvName, samples := range pvMetricsSamples {
+		if len(samples) == 0 {
+			pvMetricsValue[pvName] = 0
+			continue
+		}
+		pvMetricsValue[pvName] = samples[len(samples)-1].Value
+	}
+	return pvMetricsValue
 }
 
 // GetPVList fetch and update the list of PV.
+// Once the informers are started, the lists are updated by their events and
+// GetPVList does nothing.
 func (p *PVMetrics) GetPVList() {
+	if p.listers != nil {
+		return
+	}
+
 	pvList, err := p.ClientSet.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
 	if err != nil {
+		p.stats.apiError("persistentvolumes", err)
 		log.Error(err)
 		return
 	}
+	podList, podErr := p.GetPodList()
+	if podErr != nil {
+		p.stats.apiError("pods", podErr)
+		log.Error(podErr)
+	}
+	scList, scErr := p.GetSCList()
+	if scErr != nil {
+		p.stats.apiError("storageclasses", scErr)
+		log.Error(scErr)
+	}
+
+	p.update(func(s *Snapshot) {
+		s.PVList = p.PVNameAndUID(pvList.Items)
+		s.PVCList = p.PVNameAndClaimUID(pvList.Items)
+		if podErr == nil {
+			s.PodList = p.PVNameAndPodUIDs(pvList.Items, podList)
+			s.Details = p.PVNameAndDetails(pvList.Items, podList)
+		}
+		if scErr == nil {
+			s.SCList = p.PVNameAndSCUID(pvList.Items, scList)
+			s.Expandable = p.PVNameAndExpandable(pvList.Items, scList)
+		}
+	})
+}
+
+// GetPodList fetch the list of pods in the pod namespace.
+func (p *PVMetrics) GetPodList() ([]corev1.Pod, error) {
+	podList, err := p.ClientSet.CoreV1().Pods(p.PodNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	p.PVList = p.PVNameAndUID(pvList.Items)
+	return podList.Items, nil
 }
 
 // PVNameAndUID returns the name and UID of all the PVs.
@@ -139,13 +442,89 @@ func (p *PVMetrics) PVNameAndUID(pvListItems []corev1.PersistentVolume) map[stri
 	return pvList
 }
 
-// GetContainerCountInDeployment will provide count of containers
-func (p *PVMetrics) GetContainerCountInDeployment() int {
-	deploymentSpec, err := p.ClientSet.AppsV1().Deployments("maya-system").Get("openebs-monitor-plugin", metav1.GetOptions{})
+// PVNameAndClaimUID returns the UID of the PVC bound to each PV, keyed by PV name.
+// PVs which are not bound to any PVC are skipped.
+func (p *PVMetrics) PVNameAndClaimUID(pvListItems []corev1.PersistentVolume) map[string]string {
+	pvcList := make(map[string]string)
+	for _, pv := range pvListItems {
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.UID == "" {
+			continue
+		}
+		pvcList[pv.GetName()] = string(pv.Spec.ClaimRef.UID)
+	}
+	return pvcList
+}
+
+// GetSCList fetch the list of StorageClasses.
+func (p *PVMetrics) GetSCList() ([]storagev1.StorageClass, error) {
+	scList, err := p.ClientSet.StorageV1().StorageClasses().List(metav1.ListOptions{})
 	if err != nil {
-		return 0
+		return nil, err
+	}
+
+	return scList.Items, nil
+}
+
+// PVNameAndSCUID returns the UID of the StorageClass of each PV, keyed by PV name.
+func (p *PVMetrics) PVNameAndSCUID(pvListItems []corev1.PersistentVolume, scListItems []storagev1.StorageClass) map[string]string {
+	scUIDs := make(map[string]string)
+	for _, sc := range scListItems {
+		scUIDs[sc.GetName()] = string(sc.GetUID())
+	}
+
+	scList := make(map[string]string)
+	for _, pv := range pvListItems {
+		scUID, ok := scUIDs[pv.Spec.StorageClassName]
+		if !ok {
+			continue
+		}
+		scList[pv.GetName()] = scUID
+	}
+	return scList
+}
+
+// PVNameAndExpandable returns whether the StorageClass of each PV allows
+// volume expansion, keyed by PV name.
+func (p *PVMetrics) PVNameAndExpandable(pvListItems []corev1.PersistentVolume, scListItems []storagev1.StorageClass) map[string]bool {
+	expandable := make(map[string]bool)
+	for _, sc := range scListItems {
+		expandable[sc.GetName()] = sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion
+	}
+
+	pvExpandable := make(map[string]bool)
+	for _, pv := range pvListItems {
+		if expandable[pv.Spec.StorageClassName] {
+			pvExpandable[pv.GetName()] = true
+		}
+	}
+	return pvExpandable
+}
+
+// PVNameAndPodUIDs returns the UIDs of the pods mounting the PVC bound to
+// each PV, keyed by PV name.
+func (p *PVMetrics) PVNameAndPodUIDs(pvListItems []corev1.PersistentVolume, podListItems []corev1.Pod) map[string][]string {
+	claims := make(map[string]string)
+	for _, pv := range pvListItems {
+		if pv.Spec.ClaimRef == nil {
+			continue
+		}
+		claims[pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name] = pv.GetName()
+	}
+
+	podList := make(map[string][]string)
+	for _, pod := range podListItems {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			pvName, ok := claims[pod.GetNamespace()+"/"+volume.PersistentVolumeClaim.ClaimName]
+			if !ok {
+				continue
+			}
+			podList[pvName] = append(podList[pvName], string(pod.GetUID()))
+		}
 	}
-	return len(deploymentSpec.Spec.Template.Spec.Containers)
+	return podList
 }
 
 // UnmarshalResponse unmarshal the obtained Metric json.
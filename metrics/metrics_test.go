@@ -1,13 +1,17 @@
 package metrics
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/openebs/scope-plugin/k8s"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -50,6 +54,20 @@ var FieldsWithSixQuery = &fields{
 	ClientSet: fake.NewSimpleClientset(),
 }
 
+// newPVMetrics returns a PVMetrics with the queries and client of f and a
+// snapshot of its PV list and data.
+func (f *fields) newPVMetrics() *PVMetrics {
+	p := &PVMetrics{
+		Queries:   f.Queries,
+		ClientSet: f.ClientSet,
+	}
+	p.snapshot.Store(&Snapshot{
+		PVList: f.PVList,
+		Data:   f.Data,
+	})
+	return p
+}
+
 func (f *fields) createPVUsingFakeClient(persistentVolume *corev1.PersistentVolume) error {
 	_, err := f.ClientSet.CoreV1().PersistentVolumes().Create(persistentVolume)
 	return err
@@ -63,28 +81,43 @@ func (f *fields) deletePVUsingFakeClient(persistentVolumeName string) error {
 func TestNewMetrics(t *testing.T) {
 	tests := []struct {
 		name string
-		want PVMetrics
+		want *PVMetrics
 	}{
 		{
 			name: "Test NewMetrics method",
-			want: PVMetrics{
+			want: &PVMetrics{
+				Catalog: DefaultCatalog(),
 				Queries: map[string]string{
-					"iopsReadQuery":        "irate(openebs_reads[5m])",
-					"iopsWriteQuery":       "irate(openebs_writes[5m])",
-					"latencyReadQuery":     "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000",
-					"latencyWriteQuery":    "((irate(openebs_write_time[5m]))/(irate(openebs_writes[5m])))/1000000",
-					"throughputReadQuery":  "irate(openebs_read_block_count[5m])/(2048)",
-					"throughputWriteQuery": "irate(openebs_write_block_count[5m])/(2048)",
+					"iopsReadQuery":          "irate(openebs_reads[5m])",
+					"iopsWriteQuery":         "irate(openebs_writes[5m])",
+					"latencyReadQuery":       "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000",
+					"latencyWriteQuery":      "((irate(openebs_write_time[5m]))/(irate(openebs_writes[5m])))/1000000",
+					"throughputReadQuery":    "irate(openebs_read_block_count[5m])/(2048)",
+					"throughputWriteQuery":   "irate(openebs_write_block_count[5m])/(2048)",
+					"capacityQuery":          "kubelet_volume_stats_capacity_bytes",
+					"usedQuery":              "kubelet_volume_stats_used_bytes",
+					"usedPercentQuery":       "100*kubelet_volume_stats_used_bytes/kubelet_volume_stats_capacity_bytes",
+					"inodesUsedQuery":        "kubelet_volume_stats_inodes_used",
+					"inodesUsedPercentQuery": "100*kubelet_volume_stats_inodes_used/kubelet_volume_stats_inodes",
+					"poolIopsReadQuery":      "sum by (cstor_pool) (irate(openebs_total_read_count[5m]))",
+					"poolIopsWriteQuery":     "sum by (cstor_pool) (irate(openebs_total_write_count[5m]))",
+					"poolLatencyReadQuery":   "(sum by (cstor_pool) (irate(openebs_total_read_time[5m])))/(sum by (cstor_pool) (irate(openebs_total_read_count[5m])))/1000000",
+					"poolLatencyWriteQuery":  "(sum by (cstor_pool) (irate(openebs_total_write_time[5m])))/(sum by (cstor_pool) (irate(openebs_total_write_count[5m])))/1000000",
 				},
-				PVList:    nil,
-				Data:      nil,
-				ClientSet: k8s.NewClientSet(),
+				Schedule:     DefaultSchedule(),
+				QueryTimeout: DefaultQueryTimeout,
+				Workers:      DefaultWorkers,
+				Range: RangeQuery{
+					Window: DefaultRangeWindow,
+					Step:   DefaultRangeStep,
+				},
+				ClientSet: FieldsWithNilValue.ClientSet,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewMetrics(); !reflect.DeepEqual(got, tt.want) {
+			if got := NewMetrics(DefaultCatalog(), FieldsWithNilValue.ClientSet); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewMetrics() = %v, want %v", got, tt.want)
 			}
 		})
@@ -134,17 +167,12 @@ func TestPVMetrics_GetPVList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			tt.before()
 			p.GetPVList()
 			tt.after()
-			if !reflect.DeepEqual(p.PVList, tt.want) {
-				t.Errorf("PVMetrics.PVNameAndUID() = %v, want %v", p.PVList, tt.want)
+			if got := p.Snapshot().PVList; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PVMetrics.PVNameAndUID() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -187,12 +215,7 @@ func TestPVMetrics_PVNameAndUID(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			if got := p.PVNameAndUID(tt.args.pvListItems); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("PVMetrics.PVNameAndUID() = %v, want %v", got, tt.want)
 			}
@@ -200,6 +223,183 @@ func TestPVMetrics_PVNameAndUID(t *testing.T) {
 	}
 }
 
+func TestPVMetrics_PVNameAndClaimUID(t *testing.T) {
+	pvListItems := []corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV1",
+				UID:  "test1234",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{
+					Name: "testPVC1",
+					UID:  "pvc1234",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV2",
+				UID:  "test4568",
+			},
+		},
+	}
+	want := map[string]string{
+		"testPV1": "pvc1234",
+	}
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got := p.PVNameAndClaimUID(pvListItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("PVMetrics.PVNameAndClaimUID() = %v, want %v", got, want)
+	}
+}
+
+func TestPVMetrics_PVNameAndPodUIDs(t *testing.T) {
+	pvListItems := []corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV1",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: "default",
+					Name:      "testPVC1",
+				},
+			},
+		},
+	}
+	podListItems := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				UID:       "pod1234",
+			},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+								ClaimName: "testPVC1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "other",
+				UID:       "pod5678",
+			},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+								ClaimName: "testPVC1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	want := map[string][]string{
+		"testPV1": {"pod1234"},
+	}
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got := p.PVNameAndPodUIDs(pvListItems, podListItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("PVMetrics.PVNameAndPodUIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestPVMetrics_PVNameAndSCUID(t *testing.T) {
+	pvListItems := []corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV1",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName: "openebs-cstor",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "testPV2",
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName: "unknown",
+			},
+		},
+	}
+	scListItems := []storagev1.StorageClass{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "openebs-cstor",
+				UID:  "sc1234",
+			},
+		},
+	}
+	want := map[string]string{
+		"testPV1": "sc1234",
+	}
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got := p.PVNameAndSCUID(pvListItems, scListItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("PVMetrics.PVNameAndSCUID() = %v, want %v", got, want)
+	}
+}
+
+func TestPVMetrics_PVNameAndExpandable(t *testing.T) {
+	allowed, notAllowed := true, false
+	pvListItems := []corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testPV1"},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: "expandable"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testPV2"},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: "fixed"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testPV3"},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: "default"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "testPV4"},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: "unknown"},
+		},
+	}
+	scListItems := []storagev1.StorageClass{
+		{
+			ObjectMeta:           metav1.ObjectMeta{Name: "expandable"},
+			AllowVolumeExpansion: &allowed,
+		},
+		{
+			ObjectMeta:           metav1.ObjectMeta{Name: "fixed"},
+			AllowVolumeExpansion: &notAllowed,
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		},
+	}
+	want := map[string]bool{
+		"testPV1": true,
+	}
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got := p.PVNameAndExpandable(pvListItems, scListItems); !reflect.DeepEqual(got, want) {
+		t.Errorf("PVMetrics.PVNameAndExpandable() = %v, want %v", got, want)
+	}
+}
+
 func TestPVMetrics_UnmarshalResponse(t *testing.T) {
 	var value []interface{}
 	value = append(value, 1540812781.106)
@@ -227,12 +427,11 @@ func TestPVMetrics_UnmarshalResponse(t *testing.T) {
 					Result: []Result{
 						{
 							Metric: Metric{
-								Name:              "",
-								Instance:          "10.16.1.4:9500",
-								Job:               "cluster_uuid_df75f04a-9ca5-4d19-bdac-05246aee6ddc_openebs-volumes",
-								KubernetesPodName: "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a-ctrl-87b9c4fd9-qrn9v",
-								OpenebsPv:         "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a",
-								OpenebsPvc:        "demo-vol1-claim",
+								"instance":            "10.16.1.4:9500",
+								"job":                 "cluster_uuid_df75f04a-9ca5-4d19-bdac-05246aee6ddc_openebs-volumes",
+								"kubernetes_pod_name": "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a-ctrl-87b9c4fd9-qrn9v",
+								"openebs_pv":          "pvc-f53a1eb1-d8e4-11e8-9e9b-42010a80009a",
+								"openebs_pvc":         "demo-vol1-claim",
 							},
 							Value: value,
 						},
@@ -244,12 +443,7 @@ func TestPVMetrics_UnmarshalResponse(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			got, err := p.UnmarshalResponse(tt.args.response)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PVMetrics.UnmarshalResponse() error = %v, wantErr %v", err, tt.wantErr)
@@ -264,70 +458,45 @@ func TestPVMetrics_UnmarshalResponse(t *testing.T) {
 
 func TestPVMetrics_UpdatePVMetrics(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields *fields
-		want   *PVMetrics
+		name       string
+		fields     *fields
+		wantPVList map[string]string
+		wantData   map[string]map[string]float64
 	}{
 		{
-			name:   "When query is nil",
-			fields: FieldsWithNilValue,
-			want: &PVMetrics{
-				Queries:   nil,
-				PVList:    map[string]string{},
-				Data:      map[string]map[string]float64{},
-				ClientSet: FieldsWithNilValue.ClientSet,
-			},
+			name:       "When query is nil",
+			fields:     FieldsWithNilValue,
+			wantPVList: map[string]string{},
+			wantData:   map[string]map[string]float64{},
 		},
 		{
-			name:   "When one query is present",
-			fields: FieldsWithOneQuery,
-			want: &PVMetrics{
-				Queries: map[string]string{
-					"iopsReadQuery": "testIopsReadQuery",
-				},
-				PVList:    map[string]string{},
-				Data:      nil,
-				ClientSet: FieldsWithOneQuery.ClientSet,
-			},
+			name:       "When one query is present",
+			fields:     FieldsWithOneQuery,
+			wantPVList: map[string]string{},
+			wantData:   nil,
 		},
 		{
-			name:   "When more than one query is present",
-			fields: FieldsWithSixQuery,
-			want: &PVMetrics{
-				Queries: map[string]string{
-					"iopsReadQuery":        "testIopsReadQuery",
-					"iopsWriteQuery":       "testIopsWriteQuery",
-					"latencyReadQuery":     "testLatencyReadQuery",
-					"latencyWriteQuery":    "testLatencyWriteQuery",
-					"throughputReadQuery":  "testThroughputReadQuery",
-					"throughputWriteQuery": "testThroughputWriteQuery",
-				},
-				PVList:    map[string]string{},
-				Data:      nil,
-				ClientSet: FieldsWithSixQuery.ClientSet,
-			},
+			name:       "When more than one query is present",
+			fields:     FieldsWithSixQuery,
+			wantPVList: map[string]string{},
+			wantData:   nil,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
-			p.UpdatePVMetrics()
-			if !reflect.DeepEqual(p.Queries, tt.want.Queries) {
-				t.Errorf("PVMetrics.Queries = %v, want.Queries %v", p.Queries, tt.want.Queries)
+			p := tt.fields.newPVMetrics()
+			p.UpdatePVMetrics(context.Background())
+			if !reflect.DeepEqual(p.Queries, tt.fields.Queries) {
+				t.Errorf("PVMetrics.Queries = %v, want %v", p.Queries, tt.fields.Queries)
 			}
-			if !reflect.DeepEqual(p.PVList, tt.want.PVList) {
-				t.Errorf("PVMetrics.PVList = %v, want.PVList %v", p.PVList, tt.want.PVList)
+			if got := p.Snapshot().PVList; !reflect.DeepEqual(got, tt.wantPVList) {
+				t.Errorf("PVMetrics.PVList = %v, want %v", got, tt.wantPVList)
 			}
-			if !reflect.DeepEqual(p.Data, tt.want.Data) {
-				t.Errorf("PVMetrics.Data = %v, want.Data %v", p.Data, tt.want.Data)
+			if got := p.Snapshot().Data; !reflect.DeepEqual(got, tt.wantData) {
+				t.Errorf("PVMetrics.Data = %v, want %v", got, tt.wantData)
 			}
-			if !reflect.DeepEqual(p.ClientSet, tt.want.ClientSet) {
-				t.Errorf("PVMetrics.ClientSet = %v, want.ClientSet %v", p.ClientSet, tt.want.ClientSet)
+			if !reflect.DeepEqual(p.ClientSet, tt.fields.ClientSet) {
+				t.Errorf("PVMetrics.ClientSet = %v, want %v", p.ClientSet, tt.fields.ClientSet)
 			}
 		})
 	}
@@ -336,7 +505,6 @@ func TestPVMetrics_UpdatePVMetrics(t *testing.T) {
 func TestPVMetrics_GetMetrics(t *testing.T) {
 	var testServer *httptest.Server
 
-	tempURL := URL
 	respHavingNoResult := `{"status":"success","data":{"resultType":"vector","result":[]}}`
 	respHavingResultAsNaN := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"OpenEBS__iops","instance":"172.17.0.2:9500","job":"cluster_uuid_9aba2480-a180-41ca-b5cb-f4a099376a16_openebs-volumes","kubernetes_pod_name":"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83-ctrl-745784bb48-z9pl8","openebs_pv":"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83"},"value":[1528354477.902, "NaN"]}]}}`
 	respHavingProperResult := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"OpenEBS__iops","instance":"172.17.0.2:9500","job":"cluster_uuid_9aba2480-a180-41ca-b5cb-f4a099376a16_openebs-volumes","kubernetes_pod_name":"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83-ctrl-745784bb48-z9pl8","openebs_pv":"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83"},"value":[1528354477.902, "5"]}]}}`
@@ -366,10 +534,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(http.StatusServiceUnavailable)
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -385,10 +551,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte{})
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -404,10 +568,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(`OK`))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -423,10 +585,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(respHavingNoResult))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -444,10 +604,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(respHavingResultAsNaN))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -465,10 +623,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(respWithInf))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -486,10 +642,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(respHavingResultAsInf))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -507,10 +661,8 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 				testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.Write([]byte(respHavingProperResult))
 				}))
-				URL = testServer.URL
 			},
 			after: func() {
-				URL = tempURL
 				testServer.Close()
 			},
 		},
@@ -518,13 +670,9 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 	for _, tt := range tests {
 		tt.before()
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
-			got, err := p.GetMetrics(tt.args.query)
+			p := tt.fields.newPVMetrics()
+			p.Source = NewPrometheusSource(testServer.URL)
+			got, err := p.GetMetrics(context.Background(), tt.args.query)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PVMetrics.GetMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -536,3 +684,276 @@ func TestPVMetrics_GetMetrics(t *testing.T) {
 		tt.after()
 	}
 }
+
+func TestPVMetrics_GetRangeMetrics(t *testing.T) {
+	var testServer *httptest.Server
+	var gotQuery string
+
+	respHavingNoResult := `{"status":"success","data":{"resultType":"matrix","result":[]}}`
+	respHavingProperResult := `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"OpenEBS__iops","openebs_pv":"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83"},"values":[[1528354470, "5"],[1528354485.5, "NaN"],[1528354500, "7"]]}]}}`
+	tests := []struct {
+		name     string
+		response string
+		want     map[string][]sample
+		wantErr  bool
+	}{
+		{
+			name:     "when server is started and giving proper JSON as response but result is empty",
+			response: respHavingNoResult,
+			want:     nil,
+			wantErr:  true,
+		},
+		{
+			name:     "when server is started and giving proper JSON as response",
+			response: respHavingProperResult,
+			want: map[string][]sample{
+				"pvc-4fa13b09-6242-11e8-a310-1458d00e6b83": {
+					{Date: time.Unix(1528354470, 0), Value: 5},
+					{Date: time.Unix(1528354485, 500000000), Value: 0},
+					{Date: time.Unix(1528354500, 0), Value: 7},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotQuery = r.URL.RawQuery
+			w.Write([]byte(tt.response))
+		}))
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PVMetrics{
+				Range: RangeQuery{
+					Window: 2 * time.Minute,
+					Step:   30 * time.Second,
+				},
+				Source:    NewPrometheusSource(testServer.URL),
+				ClientSet: FieldsWithNilValue.ClientSet,
+			}
+			got, err := p.GetRangeMetrics(context.Background(), "testQuery")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PVMetrics.GetRangeMetrics() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PVMetrics.GetRangeMetrics() = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(gotQuery, "step=30") {
+				t.Errorf("PVMetrics.GetRangeMetrics() query = %v, want step=30", gotQuery)
+			}
+		})
+		testServer.Close()
+	}
+}
+
+func TestPVMetrics_runQueryPoolHistory(t *testing.T) {
+	var mutex sync.Mutex
+	paths := make(map[string]string)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths[r.URL.Query().Get("query")] = r.URL.Path
+		mutex.Unlock()
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer testServer.Close()
+
+	p := &PVMetrics{
+		Catalog: DefaultCatalog(),
+		Range: RangeQuery{
+			Window: time.Minute,
+			Step:   15 * time.Second,
+		},
+		Source:    NewPrometheusSource(testServer.URL),
+		ClientSet: fake.NewSimpleClientset(),
+	}
+	// The pool queries are not shown with their history, which is not fetched.
+	want := map[string]string{
+		"irate(openebs_reads[5m])":                                  "/api/v1/query_range",
+		"sum by (cstor_pool) (irate(openebs_total_read_count[5m]))": "/api/v1/query",
+	}
+	for query := range want {
+		for _, catalogQuery := range p.Catalog {
+			if catalogQuery.PromQL == query {
+				p.runQuery(context.Background(), catalogQuery.Name, query)
+			}
+		}
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("PVMetrics.runQuery() paths = %v, want %v", paths, want)
+	}
+}
+
+func TestPVMetrics_GetMetricsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer func() {
+		close(release)
+		testServer.Close()
+	}()
+
+	p := &PVMetrics{
+		QueryTimeout: 10 * time.Millisecond,
+		Source:       NewPrometheusSource(testServer.URL),
+		ClientSet:    FieldsWithNilValue.ClientSet,
+	}
+	start := time.Now()
+	if _, err := p.GetMetrics(context.Background(), "testQuery"); err == nil {
+		t.Errorf("PVMetrics.GetMetrics() error = nil, want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("PVMetrics.GetMetrics() took %v, want it to time out", elapsed)
+	}
+}
+
+func TestPVMetrics_UpdatePVMetricsConcurrently(t *testing.T) {
+	respHavingProperResult := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"openebs_pv":"testPV"},"value":[1528354477.902, "5"]}]}}`
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		w.Write([]byte(respHavingProperResult))
+	}))
+	defer testServer.Close()
+
+	p := &PVMetrics{
+		Queries:   FieldsWithSixQuery.Queries,
+		Workers:   2,
+		Source:    NewPrometheusSource(testServer.URL),
+		ClientSet: fake.NewSimpleClientset(),
+	}
+	if err := p.UpdatePVMetrics(context.Background()); err != nil {
+		t.Fatalf("PVMetrics.UpdatePVMetrics() error = %v", err)
+	}
+	if len(p.Snapshot().Data) != len(p.Queries) {
+		t.Errorf("PVMetrics.Data = %v, want %d queries", p.Snapshot().Data, len(p.Queries))
+	}
+	if len(p.stats.queries) != len(p.Queries) {
+		t.Errorf("PVMetrics.stats.queries = %v, want %d queries", p.stats.queries, len(p.Queries))
+	}
+	for queryName, stats := range p.stats.queries {
+		if stats.count != 1 || stats.seconds < 0.02 {
+			t.Errorf("PVMetrics.stats.queries[%s] = %+v, want 1 query of at least 20ms", queryName, *stats)
+		}
+	}
+	if maxInFlight > 2 {
+		t.Errorf("PVMetrics.UpdatePVMetrics() ran %d queries concurrently, want at most 2", maxInFlight)
+	}
+}
+
+func TestPVMetrics_UpdatePVMetricsPartially(t *testing.T) {
+	respHavingProperResult := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"openebs_pv":"testPV"},"value":[1528354477.902, "5"]}]}}`
+	respHavingEmptyResult := `{"status":"success","data":{"resultType":"vector","result":[]}}`
+	var mutex sync.Mutex
+	failing := "testIopsWriteQuery"
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Query().Get("query") {
+		case failing:
+			http.Error(w, "query failed", http.StatusInternalServerError)
+		case "testLatencyWriteQuery":
+			w.Write([]byte(respHavingEmptyResult))
+		default:
+			w.Write([]byte(respHavingProperResult))
+		}
+	}))
+	defer testServer.Close()
+
+	p := &PVMetrics{
+		Queries:   FieldsWithSixQuery.Queries,
+		Source:    NewPrometheusSource(testServer.URL),
+		ClientSet: fake.NewSimpleClientset(),
+	}
+	if err := p.UpdatePVMetrics(context.Background()); err != nil {
+		t.Fatalf("PVMetrics.UpdatePVMetrics() error = %v, want nil when some queries succeed", err)
+	}
+	if _, ok := p.Snapshot().Data["iopsWriteQuery"]; ok {
+		t.Errorf("PVMetrics.Data[iopsWriteQuery] = %v, want no data", p.Snapshot().Data["iopsWriteQuery"])
+	}
+	if got := p.Snapshot().Data["iopsReadQuery"]["testPV"]; got != 5 {
+		t.Errorf("PVMetrics.Data[iopsReadQuery][testPV] = %v, want 5", got)
+	}
+	if got, ok := p.Snapshot().Data["latencyWriteQuery"]; !ok || len(got) != 0 {
+		t.Errorf("PVMetrics.Data[latencyWriteQuery] = %v, want an empty result", got)
+	}
+	if status := p.Snapshot().Status["iopsWriteQuery"]; !status.Stale() || !status.Updated.IsZero() {
+		t.Errorf("PVMetrics.Status[iopsWriteQuery] = %+v, want a failed query", status)
+	}
+	if got := p.metricTemplates(p.Snapshot())["writeIops"].Label; got != "Iops(W) (unavailable)" {
+		t.Errorf("writeIops label = %q, want %q", got, "Iops(W) (unavailable)")
+	}
+
+	// The next refresh fails for another query, which keeps its data.
+	mutex.Lock()
+	failing = "testIopsReadQuery"
+	mutex.Unlock()
+	if err := p.UpdatePVMetrics(context.Background()); err != nil {
+		t.Fatalf("PVMetrics.UpdatePVMetrics() error = %v, want nil when some queries succeed", err)
+	}
+	if got := p.Snapshot().Data["iopsReadQuery"]["testPV"]; got != 5 {
+		t.Errorf("PVMetrics.Data[iopsReadQuery][testPV] = %v, want the previous value 5", got)
+	}
+	if got := p.Snapshot().Data["iopsWriteQuery"]["testPV"]; got != 5 {
+		t.Errorf("PVMetrics.Data[iopsWriteQuery][testPV] = %v, want 5", got)
+	}
+	if status := p.Snapshot().Status["iopsReadQuery"]; !status.Stale() || status.Updated.IsZero() {
+		t.Errorf("PVMetrics.Status[iopsReadQuery] = %+v, want a stale query", status)
+	}
+	if got := p.metricTemplates(p.Snapshot())["readIops"].Label; got != "Iops(R) (stale)" {
+		t.Errorf("readIops label = %q, want %q", got, "Iops(R) (stale)")
+	}
+	if got := p.metricTemplates(p.Snapshot())["writeIops"].Label; got != "Iops(W)" {
+		t.Errorf("writeIops label = %q, want %q", got, "Iops(W)")
+	}
+}
+
+func TestPVMetrics_runQueryFallback(t *testing.T) {
+	p := &PVMetrics{
+		Catalog: DefaultCatalog(),
+		Source: &FixtureSource{
+			Series: map[string][]FixtureSeries{
+				"kubelet_volume_stats_used_bytes": {
+					{Metric: Metric{"persistentvolumeclaim": "data", "namespace": "default"}, Value: 2048},
+				},
+				"openebs_actual_used*1073741824": {
+					{Metric: Metric{"openebs_pv": "pvc-1"}, Value: 1024},
+					{Metric: Metric{"openebs_pv": "pvc-2"}, Value: 3 << 30},
+				},
+			},
+		},
+		ClientSet: fake.NewSimpleClientset(),
+	}
+	p.snapshot.Store(&Snapshot{
+		Details: map[string]PVDetails{
+			"pvc-1": {Claim: "data", ClaimNamespace: "default"},
+		},
+	})
+
+	got := p.runQuery(context.Background(), "usedQuery", "kubelet_volume_stats_used_bytes")
+	if got.err != nil {
+		t.Fatalf("runQuery() error = %v", got.err)
+	}
+	want := map[string]float64{
+		"pvc-1": 2048,
+		"pvc-2": 3 << 30,
+	}
+	if !reflect.DeepEqual(got.values, want) {
+		t.Errorf("runQuery() = %v, want %v", got.values, want)
+	}
+
+	got = p.runQuery(context.Background(), "inodesUsedQuery", "kubelet_volume_stats_inodes_used")
+	if got.err != nil || len(got.values) != 0 {
+		t.Errorf("runQuery() = %v, %v, want an empty result without fallback", got.values, got.err)
+	}
+}
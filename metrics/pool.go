@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The cStor pools are not reported as a topology of their own: scope only
+// renders the topologies it knows of, and drops the others from the plugin
+// reports. Each pool is shown instead as a row of the pool table of the host
+// node it runs on, with its capacity, saturation and IO metrics.
+
+const (
+	// hostPoolsPrefix is the prefix of the latest keys shown in the pool table
+	// of the host nodes.
+	hostPoolsPrefix = "openebs_host_pool_"
+	// hostPoolsTable is the ID of the pool table of the host nodes.
+	hostPoolsTable = "openebs_host_pools"
+)
+
+var (
+	// CStorPoolResource is the resource of the cStor pools.
+	CStorPoolResource = schema.GroupVersionResource{
+		Group:    "openebs.io",
+		Version:  "v1alpha1",
+		Resource: "cstorpools",
+	}
+	// CStorPoolInstanceResource is the resource of the cStor pool instances,
+	// which replace the cStor pools in newer OpenEBS releases.
+	CStorPoolInstanceResource = schema.GroupVersionResource{
+		Group:    "cstor.openebs.io",
+		Version:  "v1",
+		Resource: "cstorpoolinstances",
+	}
+)
+
+// CStorPool describes a cStor pool and the host it runs on. The capacities
+// are the quantities reported by the pool, e.g. "9.94G".
+type CStorPool struct {
+	UID   string
+	Host  string
+	Phase string
+	Total string
+	Used  string
+	Free  string
+}
+
+// GetCStorPoolList fetch and update the cStor pools, keyed by pool name.
+// Both the cStor pools and the cStor pool instances are listed, and clusters
+// where neither is defined have no cStor pools. Once the informers are
+// started, the pools are updated by their events and GetCStorPoolList does
+// nothing.
+func (p *PVMetrics) GetCStorPoolList() {
+	if p.DynamicClient == nil || p.listers != nil {
+		return
+	}
+
+	var poolItems []unstructured.Unstructured
+	listed := false
+	poolList, err := p.DynamicClient.Resource(CStorPoolResource).List(metav1.ListOptions{})
+	if err != nil {
+		p.stats.apiError(CStorPoolResource.Resource, err)
+		log.Debugf("Failed to list cStor pools: %v", err)
+	} else {
+		poolItems = append(poolItems, poolList.Items...)
+		listed = true
+	}
+	instanceList, err := p.DynamicClient.Resource(CStorPoolInstanceResource).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		p.stats.apiError(CStorPoolInstanceResource.Resource, err)
+		log.Debugf("Failed to list cStor pool instances: %v", err)
+	} else {
+		poolItems = append(poolItems, instanceList.Items...)
+		listed = true
+	}
+	if !listed {
+		return
+	}
+
+	pools := p.CStorPoolNameAndPool(poolItems)
+	p.update(func(s *Snapshot) {
+		s.CStorPools = pools
+	})
+}
+
+// syncCStorPools updates the cStor pools from the informer caches.
+func (p *PVMetrics) syncCStorPools() {
+	if p.listers.cstorPool == nil && p.listers.cstorPoolInstance == nil {
+		return
+	}
+	poolItems := append(unstructuredItems(p.listers.cstorPool), unstructuredItems(p.listers.cstorPoolInstance)...)
+	pools := p.CStorPoolNameAndPool(poolItems)
+	p.update(func(s *Snapshot) {
+		s.CStorPools = pools
+	})
+}
+
+// CStorPoolNameAndPool returns the cStor pool of each pool or pool instance,
+// keyed by pool name.
+func (p *PVMetrics) CStorPoolNameAndPool(poolItems []unstructured.Unstructured) map[string]CStorPool {
+	pools := make(map[string]CStorPool)
+	for _, item := range poolItems {
+		host, _, _ := unstructured.NestedString(item.Object, "spec", "hostName")
+		if host == "" {
+			host = item.GetLabels()["kubernetes.io/hostname"]
+		}
+		phase, _, _ := unstructured.NestedString(item.Object, "status", "phase")
+		capacity := make(map[string]string)
+		for _, field := range []string{"total", "used", "free"} {
+			capacity[field], _, _ = unstructured.NestedString(item.Object, "status", "capacity", field)
+		}
+		pools[item.GetName()] = CStorPool{
+			UID:   string(item.GetUID()),
+			Host:  host,
+			Phase: phase,
+			Total: capacity["total"],
+			Used:  capacity["used"],
+			Free:  capacity["free"],
+		}
+	}
+	return pools
+}
+
+// getHostTopology will create the ID of the scope host node of a host name.
+func (p *PVMetrics) getHostTopology(host string) string {
+	return fmt.Sprintf("%s;<host>", host)
+}
+
+// hostNodes returns the host nodes running cStor pools, with a table of
+// their pools. The table shows the capacity and saturation of each pool and
+// the values of the pool queries of the catalog, since scope has no topology
+// for the pools themselves.
+func (p *PVMetrics) hostNodes(s *Snapshot) map[string]node {
+	if len(s.CStorPools) == 0 {
+		return nil
+	}
+
+	catalog := p.poolCatalog()
+	now := time.Now()
+	nodes := make(map[string]node)
+	for poolName, pool := range s.CStorPools {
+		if pool.Host == "" {
+			continue
+		}
+		hostID := p.getHostTopology(pool.Host)
+		hostNode, ok := nodes[hostID]
+		if !ok {
+			hostNode = node{
+				Metrics: map[string]metric{},
+				Latest:  map[string]latestEntry{},
+			}
+		}
+		columns := map[string]string{
+			"name":       poolName,
+			"phase":      pool.Phase,
+			"capacity":   pool.Total,
+			"used":       pool.Used,
+			"free":       pool.Free,
+			"saturation": pool.saturation(),
+		}
+		for _, query := range catalog {
+			if value, ok := s.Data[query.Name][poolName]; ok {
+				columns[query.ID] = formatColumn(query, value)
+			}
+		}
+		for column, value := range columns {
+			if value == "" {
+				continue
+			}
+			hostNode.Latest[hostPoolsPrefix+poolName+"___"+column] = latestEntry{
+				Timestamp: now,
+				Value:     value,
+			}
+		}
+		nodes[hostID] = hostNode
+	}
+	return nodes
+}
+
+// saturation returns the used space of the pool as a percentage of its
+// capacity, empty if either is unknown.
+func (pool CStorPool) saturation() string {
+	total, err := resource.ParseQuantity(pool.Total)
+	if err != nil || total.IsZero() {
+		return ""
+	}
+	used, err := resource.ParseQuantity(pool.Used)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", float64(used.Value())/float64(total.Value())*100)
+}
+
+// formatColumn returns the value of query as shown in a table column, in the
+// unit of its format.
+func formatColumn(query Query, value float64) string {
+	text := strconv.FormatFloat(value, 'f', 2, 64)
+	if query.Round {
+		text = strconv.FormatFloat(math.Round(value), 'f', 0, 64)
+	}
+	switch query.Format {
+	case "millisecond":
+		return text + " ms"
+	case "percent":
+		return text + "%"
+	}
+	return text
+}
+
+// hostTableTemplates returns the pool table of the host node panel, with a
+// column for each pool query of the catalog.
+func (p *PVMetrics) hostTableTemplates() map[string]tableTemplate {
+	columns := []column{
+		{ID: "name", Label: "Name"},
+		{ID: "phase", Label: "Phase"},
+		{ID: "capacity", Label: "Capacity"},
+		{ID: "used", Label: "Used"},
+		{ID: "free", Label: "Free"},
+		{ID: "saturation", Label: "Saturation"},
+	}
+	for _, query := range p.poolCatalog() {
+		columns = append(columns, column{ID: query.ID, Label: query.Label})
+	}
+	return map[string]tableTemplate{
+		hostPoolsTable: {
+			ID:      hostPoolsTable,
+			Label:   "cStor pools",
+			Prefix:  hostPoolsPrefix,
+			Type:    multiColumnTableType,
+			Columns: columns,
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func TestPVMetrics_GetCStorPoolList(t *testing.T) {
+	pools := `{"apiVersion":"openebs.io/v1alpha1","kind":"CStorPoolList","items":[
+		{"apiVersion":"openebs.io/v1alpha1","kind":"CStorPool","metadata":{"name":"pool-a","uid":"pool1234","labels":{"kubernetes.io/hostname":"node-a"}},"status":{"phase":"Healthy","capacity":{"total":"10G","used":"4G","free":"6G"}}}]}`
+	instances := `{"apiVersion":"cstor.openebs.io/v1","kind":"CStorPoolInstanceList","items":[
+		{"apiVersion":"cstor.openebs.io/v1","kind":"CStorPoolInstance","metadata":{"name":"pool-b","namespace":"openebs","uid":"pool5678"},"spec":{"hostName":"node-b"},"status":{"phase":"ONLINE","capacity":{"total":"20Gi","used":"5Gi","free":"15Gi"}}}]}`
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/openebs.io/v1alpha1/cstorpools":
+			w.Write([]byte(pools))
+		case "/apis/cstor.openebs.io/v1/cstorpoolinstances":
+			w.Write([]byte(instances))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer testServer.Close()
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: testServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PVMetrics{
+		DynamicClient: dynamicClient,
+	}
+	p.GetCStorPoolList()
+
+	want := map[string]CStorPool{
+		"pool-a": {UID: "pool1234", Host: "node-a", Phase: "Healthy", Total: "10G", Used: "4G", Free: "6G"},
+		"pool-b": {UID: "pool5678", Host: "node-b", Phase: "ONLINE", Total: "20Gi", Used: "5Gi", Free: "15Gi"},
+	}
+	if got := p.Snapshot().CStorPools; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot().CStorPools = %+v, want %+v", got, want)
+	}
+}
+
+func TestPVMetrics_GetCStorPoolListWithoutCStor(t *testing.T) {
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	defer testServer.Close()
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: testServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PVMetrics{
+		DynamicClient: dynamicClient,
+	}
+	p.GetCStorPoolList()
+	if got := p.Snapshot().CStorPools; got != nil {
+		t.Errorf("Snapshot().CStorPools = %v, want nil", got)
+	}
+}
+
+func TestPVMetrics_makeReportWithPools(t *testing.T) {
+	p := &PVMetrics{}
+	p.update(func(s *Snapshot) {
+		s.CStorPools = map[string]CStorPool{
+			"pool-a": {UID: "pool1234", Host: "node-a", Phase: "Healthy", Total: "10G", Used: "4G", Free: "6G"},
+		}
+		s.Data = map[string]map[string]float64{
+			"poolIopsReadQuery": {"pool-a": 12.4},
+		}
+	})
+
+	rpt := p.makeReport()
+	hostNode, ok := rpt.Host.Nodes["node-a;<host>"]
+	if !ok {
+		t.Fatalf("makeReport() Host nodes = %v, want node-a;<host>", rpt.Host.Nodes)
+	}
+	wantColumns := map[string]string{
+		"free":         "6G",
+		"saturation":   "40%",
+		"poolReadIops": "12",
+	}
+	for column, want := range wantColumns {
+		if got := hostNode.Latest[hostPoolsPrefix+"pool-a___"+column].Value; got != want {
+			t.Errorf("host pool %s = %q, want %q", column, got, want)
+		}
+	}
+	if entry, ok := hostNode.Latest[hostPoolsPrefix+"pool-a___poolWriteIops"]; ok {
+		t.Errorf("host pool write IOPS = %v, want no value", entry)
+	}
+
+	var columns []string
+	for _, column := range rpt.Host.TableTemplates[hostPoolsTable].Columns {
+		columns = append(columns, column.ID)
+	}
+	wantTable := []string{"name", "phase", "capacity", "used", "free", "saturation", "poolReadIops", "poolWriteIops", "poolReadLatency", "poolWriteLatency"}
+	if !reflect.DeepEqual(columns, wantTable) {
+		t.Errorf("host pool table columns = %v, want %v", columns, wantTable)
+	}
+}
+
+func Test_formatColumn(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		value float64
+		want  string
+	}{
+		{
+			name:  "when the query is rounded",
+			query: Query{Round: true},
+			value: 12.6,
+			want:  "13",
+		},
+		{
+			name:  "when the query is in milliseconds",
+			query: Query{Format: "millisecond"},
+			value: 1.234,
+			want:  "1.23 ms",
+		},
+		{
+			name:  "when the query is a percentage",
+			query: Query{Format: "percent"},
+			value: 50,
+			want:  "50.00%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatColumn(tt.query, tt.value); got != tt.want {
+				t.Errorf("formatColumn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
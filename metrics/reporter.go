@@ -3,26 +3,17 @@ package metrics
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	queries = []string{
-		"iopsReadQuery",
-		"iopsWriteQuery",
-		"latencyReadQuery",
-		"latencyWriteQuery",
-		"throughputReadQuery",
-		"throughputWriteQuery",
-	}
-)
-
 // Report is called by scope when a new report is needed. It is part of the
 // "reporter" interface, which all plugins must implement.
 func (p *PVMetrics) Report(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	rpt := p.makeReport()
 	raw, err := json.Marshal(*rpt)
 	if err != nil {
@@ -30,6 +21,7 @@ func (p *PVMetrics) Report(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p.stats.observeReport(time.Since(start), len(raw))
 	w.WriteHeader(http.StatusOK)
 	w.Write(raw)
 }
@@ -39,71 +31,119 @@ func (p *PVMetrics) getPVTopology(PersistentVolumeUID string) string {
 	return fmt.Sprintf("%s;<persistent_volume>", PersistentVolumeUID)
 }
 
-// makeReport will create the report.
+// getPVCTopology will create a UID by appending the UID with resource name.
+func (p *PVMetrics) getPVCTopology(PersistentVolumeClaimUID string) string {
+	return fmt.Sprintf("%s;<persistent_volume_claim>", PersistentVolumeClaimUID)
+}
+
+// getPodTopology will create a UID by appending the UID with resource name.
+func (p *PVMetrics) getPodTopology(PodUID string) string {
+	return fmt.Sprintf("%s;<pod>", PodUID)
+}
+
+// getSCTopology will create a UID by appending the UID with resource name.
+func (p *PVMetrics) getSCTopology(StorageClassUID string) string {
+	return fmt.Sprintf("%s;<storage_class>", StorageClassUID)
+}
+
+// makeReport will create the report from the current snapshot.
 func (p *PVMetrics) makeReport() *report {
+	s := p.Snapshot()
 	metrics := make(map[string][]float64)
-	updatedMetrics := make(map[string][]float64)
 	resource := make(map[string]node)
+	claimResource := make(map[string]node)
+	podResource := make(map[string]node)
+	scResource := make(map[string]node)
+	// cStor pools are reported on their hosts even when there are no volumes.
+	hostResource := p.hostNodes(s)
 
-	for pvName := range p.PVList {
-		metrics[pvName] = []float64{0, 0, 0, 0, 0, 0}
-	}
+	catalog := p.catalog()
 
-	if p.Data != nil && p.PVList != nil && len(metrics) > 0 {
-		for index, queryName := range queries {
-			if p.Data[queryName] == nil {
-				for k := range metrics {
-					if _, ok := metrics[k]; ok {
-						metrics[k][index] = 0
-					}
-				}
-			} else {
-				for k, v := range p.Data[queryName] {
-					if _, ok := metrics[k]; ok {
-						metrics[k][index] = v
-					}
-				}
+	// Metrics without a value for a PV are NaN and left out of its node, which
+	// is reported with its details and controls even when there is no data.
+	for pvName := range s.PVList {
+		metrics[pvName] = make([]float64, len(catalog))
+		for index := range catalog {
+			metrics[pvName][index] = math.NaN()
+		}
+	}
+	for index, query := range catalog {
+		for k, v := range s.Data[query.Name] {
+			if _, ok := metrics[k]; ok {
+				metrics[k][index] = v
 			}
 		}
+	}
 
-		for pvName, pvUID := range p.PVList {
-			updatedMetrics[p.getPVTopology(pvUID)] = metrics[pvName]
+	for pvName, pvUID := range s.PVList {
+		pvcUID, bound := s.PVCList[pvName]
+		pvNode := node{
+			Metrics: p.metricsWithHistory(metrics[pvName], p.pvHistory(s, pvName)),
+			Latest:  s.Details[pvName].latest(),
 		}
-
-		for pvNodeID := range updatedMetrics {
-			resource[pvNodeID] = node{
-				Metrics: p.metrics(updatedMetrics[pvNodeID]),
+		if volume, ok := s.CStorVolumes[pvName]; ok {
+			for key, entry := range volume.latest() {
+				pvNode.Latest[key] = entry
 			}
 		}
-		rpt := &report{
-			PersistentVolume: topology{
-				Nodes:           resource,
-				MetricTemplates: p.metricTemplates(),
-			},
-			Plugins: []pluginSpec{
-				{
-					ID:          "openebs",
-					Label:       "OpenEBS Monitor Plugin",
-					Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-					Interfaces:  []string{"reporter"},
-					APIVersion:  "1",
-				},
-			},
+		for key, entry := range alertsLatest(s.Alerts[pvName]) {
+			pvNode.Latest[key] = entry
+		}
+		if bound {
+			pvcNode := pvNode
+			pvcNode.LatestControls = pvcLatestControls(s.Expandable[pvName])
+			claimResource[p.getPVCTopology(pvcUID)] = pvcNode
+		}
+		pvNode.LatestControls = pvLatestControls(bound, s.Expandable[pvName])
+		resource[p.getPVTopology(pvUID)] = pvNode
+	}
+
+	// The pods and StorageClasses are only reported with their metrics.
+	for podUID, podMetrics := range p.podMetrics(s, metrics) {
+		if podNode := (node{Metrics: p.metrics(podMetrics)}); len(podNode.Metrics) > 0 {
+			podResource[p.getPodTopology(podUID)] = podNode
+		}
+	}
+
+	for scUID, scMetrics := range p.scMetrics(s, metrics) {
+		if scNode := (node{Metrics: p.metrics(scMetrics)}); len(scNode.Metrics) > 0 {
+			scResource[p.getSCTopology(scUID)] = scNode
 		}
-		return rpt
 	}
 
 	rpt := &report{
 		PersistentVolume: topology{
-			Nodes:           nil,
-			MetricTemplates: p.metricTemplates(),
+			Nodes:             resource,
+			MetricTemplates:   p.metricTemplates(s),
+			MetadataTemplates: pvMetadataTemplates(),
+			TableTemplates:    pvTableTemplates(),
+			Controls:          p.pvControls(),
+		},
+		PersistentVolumeClaim: topology{
+			Nodes:             claimResource,
+			MetricTemplates:   p.metricTemplates(s),
+			MetadataTemplates: pvMetadataTemplates(),
+			TableTemplates:    pvTableTemplates(),
+			Controls:          p.pvcControls(),
+		},
+		Pod: topology{
+			Nodes:           podResource,
+			MetricTemplates: p.metricTemplates(s),
+		},
+		StorageClass: topology{
+			Nodes:           scResource,
+			MetricTemplates: p.metricTemplates(s),
+		},
+		Host: topology{
+			Nodes:          hostResource,
+			TableTemplates: p.hostTableTemplates(),
 		},
 		Plugins: []pluginSpec{
 			{
-				ID:          "openebs",
+				ID:          p.pluginID(),
 				Label:       "OpenEBS Monitor Plugin",
 				Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-				Interfaces:  []string{"reporter"},
+				Interfaces:  []string{"reporter", "controller"},
 				APIVersion:  "1",
 			},
 		},
@@ -111,112 +151,231 @@ func (p *PVMetrics) makeReport() *report {
 	return rpt
 }
 
+// podMetrics returns the metrics of each pod, aggregated over all the PVs
+// mounted by the pod.
+func (p *PVMetrics) podMetrics(s *Snapshot, metrics map[string][]float64) map[string][]float64 {
+	podVolumes := make(map[string][][]float64)
+	for pvName, podUIDs := range s.PodList {
+		pvMetrics, ok := metrics[pvName]
+		if !ok {
+			continue
+		}
+		for _, podUID := range podUIDs {
+			podVolumes[podUID] = append(podVolumes[podUID], pvMetrics)
+		}
+	}
+
+	podMetrics := make(map[string][]float64)
+	for podUID, volumes := range podVolumes {
+		podMetrics[podUID] = aggregate(p.catalog(), volumes)
+	}
+	return podMetrics
+}
+
+// scMetrics returns the metrics of each StorageClass, aggregated over all
+// the PVs provisioned from the StorageClass.
+func (p *PVMetrics) scMetrics(s *Snapshot, metrics map[string][]float64) map[string][]float64 {
+	scVolumes := make(map[string][][]float64)
+	for pvName, scUID := range s.SCList {
+		pvMetrics, ok := metrics[pvName]
+		if !ok {
+			continue
+		}
+		scVolumes[scUID] = append(scVolumes[scUID], pvMetrics)
+	}
+
+	scMetrics := make(map[string][]float64)
+	for scUID, volumes := range scVolumes {
+		scMetrics[scUID] = aggregate(p.catalog(), volumes)
+	}
+	return scMetrics
+}
+
+// aggregate combines the metrics of several volumes as configured in the
+// catalog. Metrics are summed unless an average is requested, which is
+// weighted by the WeightedBy metric of each volume when set. Missing (NaN)
+// values are skipped, and the result is NaN if no volume has the metric.
+func aggregate(catalog Catalog, volumes [][]float64) []float64 {
+	result := make([]float64, len(catalog))
+	for index, query := range catalog {
+		if query.Aggregate == AggregateAverage {
+			result[index] = average(volumes, index, catalog.index(query.WeightedBy))
+			continue
+		}
+		result[index] = math.NaN()
+		for _, volume := range volumes {
+			if math.IsNaN(volume[index]) {
+				continue
+			}
+			if math.IsNaN(result[index]) {
+				result[index] = 0
+			}
+			result[index] += volume[index]
+		}
+	}
+	return result
+}
+
+// average averages the metric at index value weighted by the metric at index
+// weight. If weight is negative or all the weights are zero, a plain average
+// is used. Volumes missing the metric are skipped, as are missing weights.
+func average(volumes [][]float64, value, weight int) float64 {
+	var present [][]float64
+	for _, volume := range volumes {
+		if !math.IsNaN(volume[value]) {
+			present = append(present, volume)
+		}
+	}
+	if len(present) == 0 {
+		return math.NaN()
+	}
+
+	if weight >= 0 {
+		var sum, weights float64
+		for _, volume := range present {
+			if math.IsNaN(volume[weight]) {
+				continue
+			}
+			sum += volume[value] * volume[weight]
+			weights += volume[weight]
+		}
+		if weights > 0 {
+			return sum / weights
+		}
+	}
+
+	var sum float64
+	for _, volume := range present {
+		sum += volume[value]
+	}
+	return sum / float64(len(present))
+}
+
+// pvHistory returns the samples of each query for the given PV, in the
+// same order as the catalog. It returns nil when no history is available.
+func (p *PVMetrics) pvHistory(s *Snapshot, pvName string) [][]sample {
+	if s.History == nil {
+		return nil
+	}
+
+	catalog := p.catalog()
+	history := make([][]sample, len(catalog))
+	for index, query := range catalog {
+		history[index] = s.History[query.Name][pvName]
+	}
+	return history
+}
+
 // Create the Metrics type on top-left side
 func (p *PVMetrics) metrics(data []float64) map[string]metric {
-	metrics := map[string]metric{
-		"readIops": {
-			Samples: []sample{
-				{
-					Date:  time.Now(),
-					Value: float64(int(data[0] + 0.5)),
-				},
-			},
-			Min: 0,
-			Max: 100,
-		},
-		"writeIops": {
-			Samples: []sample{
-				{
-					Date:  time.Now(),
-					Value: float64(int(data[1] + 0.5)),
-				},
-			},
-			Min: 0,
-			Max: 100,
-		},
-		"readLatency": {
-			Samples: []sample{
-				{
-					Date:  time.Now(),
-					Value: data[2],
-				},
-			},
-			Min: 0,
-			Max: 100,
-		},
-		"writeLatency": {
-			Samples: []sample{
-				{
-					Date:  time.Now(),
-					Value: data[3],
-				},
-			},
-			Min: 0,
-			Max: 100,
-		},
-		"readThroughput": {
-			Samples: []sample{
-				{
-					Date:  time.Now(),
-					Value: data[4],
-				},
-			},
-			Min: 0,
-			Max: 100,
-		},
-		"writeThroughput": {
-			Samples: []sample{
+	return p.metricsWithHistory(data, nil)
+}
+
+// metricsWithHistory creates the Metrics type using the samples in history
+// where available, falling back to a single sample of the current value.
+// Metrics with neither samples nor a value (NaN) are left out.
+func (p *PVMetrics) metricsWithHistory(data []float64, history [][]sample) map[string]metric {
+	metrics := make(map[string]metric)
+	for index, query := range p.catalog() {
+		var samples []sample
+		if index < len(history) && len(history[index]) > 0 {
+			samples = make([]sample, len(history[index]))
+			copy(samples, history[index])
+		} else if math.IsNaN(data[index]) {
+			continue
+		} else {
+			samples = []sample{
 				{
 					Date:  time.Now(),
-					Value: data[5],
+					Value: data[index],
 				},
-			},
-			Min: 0,
-			Max: 100,
-		},
+			}
+		}
+
+		if query.Round {
+			for i := range samples {
+				samples[i].Value = float64(int(samples[i].Value + 0.5))
+			}
+		}
+
+		m := newMetric(samples)
+		if query.Min != nil {
+			m.Min = *query.Min
+		}
+		if query.Max != nil {
+			m.Max = *query.Max
+		}
+		metrics[query.ID] = m
 	}
 	return metrics
 }
 
-func (p *PVMetrics) metricTemplates() map[string]metricTemplate {
-	return map[string]metricTemplate{
-		"readIops": {
-			ID:       "readIops",
-			Label:    "Iops(R)",
-			Format:   "",
-			Priority: 0.1,
-		},
-		"writeIops": {
-			ID:       "writeIops",
-			Label:    "Iops(W)",
-			Format:   "",
-			Priority: 0.2,
-		},
-		"readLatency": {
-			ID:       "readLatency",
-			Label:    "Latency(R)",
-			Format:   "millisecond",
-			Priority: 0.3,
-		},
-		"writeLatency": {
-			ID:       "writeLatency",
-			Label:    "Latency(W)",
-			Format:   "millisecond",
-			Priority: 0.4,
-		},
-		"readThroughput": {
-			ID:       "readThroughput",
-			Label:    "Throughput(R)",
-			Format:   "bytes",
-			Priority: 0.5,
-		},
-		"writeThroughput": {
-			ID:       "writeThroughput",
-			Label:    "Throughput(W)",
-			Format:   "bytes",
-			Priority: 0.6,
-		},
+// newMetric creates a metric from the given samples with Min and Max
+// computed from the sample values.
+func newMetric(samples []sample) metric {
+	m := metric{
+		Samples: samples,
+	}
+	for i, s := range samples {
+		if i == 0 || s.Value < m.Min {
+			m.Min = s.Value
+		}
+		if i == 0 || s.Value > m.Max {
+			m.Max = s.Value
+		}
+	}
+	return m
+}
+
+// metricTemplates returns the templates of the catalog metrics in snapshot s. The label
+// of a metric whose last query failed is marked as stale, or as unavailable
+// if the query never succeeded.
+func (p *PVMetrics) metricTemplates(s *Snapshot) map[string]metricTemplate {
+	metricTemplates := make(map[string]metricTemplate)
+	for _, query := range p.catalog() {
+		label := query.Label
+		if status, ok := s.Status[query.Name]; ok && status.Stale() {
+			if _, ok := s.Data[query.Name]; ok {
+				label += " (stale)"
+			} else {
+				label += " (unavailable)"
+			}
+		}
+		metricTemplates[query.ID] = metricTemplate{
+			ID:       query.ID,
+			Label:    label,
+			Format:   query.Format,
+			Priority: query.Priority,
+		}
+	}
+	return metricTemplates
+}
+
+// catalog returns the volume queries of the catalog.
+func (p *PVMetrics) catalog() Catalog {
+	return p.queryCatalog().topology(TopologyVolume)
+}
+
+// poolCatalog returns the cStor pool queries of the catalog.
+func (p *PVMetrics) poolCatalog() Catalog {
+	return p.queryCatalog().topology(TopologyPool)
+}
+
+// queryCatalog returns the query catalog of the plugin, defaulting to the
+// OpenEBS volume and pool IO metrics.
+func (p *PVMetrics) queryCatalog() Catalog {
+	if p.Catalog == nil {
+		return DefaultCatalog()
+	}
+	return p.Catalog
+}
+
+// pluginID returns the ID of the plugin reported to scope.
+func (p *PVMetrics) pluginID() string {
+	if p.PluginID == "" {
+		return "openebs"
 	}
+	return p.PluginID
 }
 
 func (p *PVMetrics) metricIDAndName() (string, string) {
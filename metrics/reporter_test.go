@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -95,6 +97,35 @@ var testMetricTemplate = map[string]metricTemplate{
 		Format:   "bytes",
 		Priority: 0.6,
 	},
+	"capacity": {
+		ID:       "capacity",
+		Label:    "Capacity",
+		Format:   "filesize",
+		Priority: 0.7,
+	},
+	"used": {
+		ID:       "used",
+		Label:    "Used",
+		Format:   "filesize",
+		Priority: 0.8,
+	},
+	"usedPercent": {
+		ID:       "usedPercent",
+		Label:    "Used %",
+		Format:   "percent",
+		Priority: 0.9,
+	},
+	"inodesUsed": {
+		ID:       "inodesUsed",
+		Label:    "Inodes used",
+		Priority: 1.0,
+	},
+	"inodesUsedPercent": {
+		ID:       "inodesUsedPercent",
+		Label:    "Inodes used %",
+		Format:   "percent",
+		Priority: 1.1,
+	},
 }
 
 func TestPVMetrics_metricTemplates(t *testing.T) {
@@ -111,13 +142,8 @@ func TestPVMetrics_metricTemplates(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
-			if got := p.metricTemplates(); !reflect.DeepEqual(got, tt.want) {
+			p := tt.fields.newPVMetrics()
+			if got := p.metricTemplates(p.Snapshot()); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("PVMetrics.metricTemplates() = %v, want %v", got, tt.want)
 			}
 		})
@@ -140,12 +166,7 @@ func TestPVMetrics_metricIDAndName(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			got, got1 := p.metricIDAndName()
 			if got != tt.want {
 				t.Errorf("PVMetrics.metricIDAndName() got = %v, want %v", got, tt.want)
@@ -171,7 +192,7 @@ func TestPVMetrics_metrics(t *testing.T) {
 			name:   "When each metrics is 0",
 			fields: FieldsWithNilValue,
 			args: args{
-				data: []float64{0, 0, 0, 0, 0, 0},
+				data: []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			},
 			want: map[string]metric{
 				"readIops": {
@@ -234,17 +255,62 @@ func TestPVMetrics_metrics(t *testing.T) {
 					Min: 0,
 					Max: 100,
 				},
+				"capacity": {
+					Samples: []sample{
+						{
+							Date:  time.Now(),
+							Value: 0,
+						},
+					},
+					Min: 0,
+					Max: 100,
+				},
+				"used": {
+					Samples: []sample{
+						{
+							Date:  time.Now(),
+							Value: 0,
+						},
+					},
+					Min: 0,
+					Max: 100,
+				},
+				"usedPercent": {
+					Samples: []sample{
+						{
+							Date:  time.Now(),
+							Value: 0,
+						},
+					},
+					Min: 0,
+					Max: 100,
+				},
+				"inodesUsed": {
+					Samples: []sample{
+						{
+							Date:  time.Now(),
+							Value: 0,
+						},
+					},
+					Min: 0,
+					Max: 100,
+				},
+				"inodesUsedPercent": {
+					Samples: []sample{
+						{
+							Date:  time.Now(),
+							Value: 0,
+						},
+					},
+					Min: 0,
+					Max: 100,
+				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			got := p.metrics(tt.args.data)
 			for k, v := range got {
 				if !reflect.DeepEqual(v.Samples[0].Value, tt.want[k].Samples[0].Value) {
@@ -284,12 +350,7 @@ func TestPVMetrics_getPVTopology(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			if got := p.getPVTopology(tt.args.PersistentVolumeUID); got != tt.want {
 				t.Errorf("PVMetrics.getPVTopology() = %v, want %v", got, tt.want)
 			}
@@ -308,15 +369,37 @@ func TestPVMetrics_makeReport(t *testing.T) {
 			fields: FieldsWithNilValue,
 			want: &report{
 				PersistentVolume: topology{
-					Nodes:           nil,
+					Nodes:             map[string]node{},
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvControls(),
+				},
+				PersistentVolumeClaim: topology{
+					Nodes:             map[string]node{},
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvcControls(),
+				},
+				Pod: topology{
+					Nodes:           map[string]node{},
 					MetricTemplates: testMetricTemplate,
 				},
+				StorageClass: topology{
+					Nodes:           map[string]node{},
+					MetricTemplates: testMetricTemplate,
+				},
+				Host: topology{
+					Nodes:          nil,
+					TableTemplates: (&PVMetrics{}).hostTableTemplates(),
+				},
 				Plugins: []pluginSpec{
 					{
 						ID:          "openebs",
 						Label:       "OpenEBS Monitor Plugin",
 						Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-						Interfaces:  []string{"reporter"},
+						Interfaces:  []string{"reporter", "controller"},
 						APIVersion:  "1",
 					},
 				},
@@ -327,15 +410,41 @@ func TestPVMetrics_makeReport(t *testing.T) {
 			fields: FieldsWithOnePV,
 			want: &report{
 				PersistentVolume: topology{
+					Nodes: map[string]node{
+						"abcdef1234;<persistent_volume>": node{
+							Metrics: map[string]metric{},
+						},
+					},
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvControls(),
+				},
+				PersistentVolumeClaim: topology{
+					Nodes:             nil,
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvcControls(),
+				},
+				Pod: topology{
+					Nodes:           nil,
+					MetricTemplates: testMetricTemplate,
+				},
+				StorageClass: topology{
 					Nodes:           nil,
 					MetricTemplates: testMetricTemplate,
 				},
+				Host: topology{
+					Nodes:          nil,
+					TableTemplates: (&PVMetrics{}).hostTableTemplates(),
+				},
 				Plugins: []pluginSpec{
 					{
 						ID:          "openebs",
 						Label:       "OpenEBS Monitor Plugin",
 						Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-						Interfaces:  []string{"reporter"},
+						Interfaces:  []string{"reporter", "controller"},
 						APIVersion:  "1",
 					},
 				},
@@ -419,7 +528,7 @@ func TestPVMetrics_makeReport(t *testing.T) {
 						ID:          "openebs",
 						Label:       "OpenEBS Monitor Plugin",
 						Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-						Interfaces:  []string{"reporter"},
+						Interfaces:  []string{"reporter", "controller"},
 						APIVersion:  "1",
 					},
 				},
@@ -503,7 +612,7 @@ func TestPVMetrics_makeReport(t *testing.T) {
 						ID:          "openebs",
 						Label:       "OpenEBS Monitor Plugin",
 						Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-						Interfaces:  []string{"reporter"},
+						Interfaces:  []string{"reporter", "controller"},
 						APIVersion:  "1",
 					},
 				},
@@ -514,15 +623,37 @@ func TestPVMetrics_makeReport(t *testing.T) {
 			fields: FieldsWithNoPV,
 			want: &report{
 				PersistentVolume: topology{
-					Nodes:           nil,
+					Nodes:             map[string]node{},
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvControls(),
+				},
+				PersistentVolumeClaim: topology{
+					Nodes:             map[string]node{},
+					MetricTemplates:   testMetricTemplate,
+					MetadataTemplates: pvMetadataTemplates(),
+					TableTemplates:    pvTableTemplates(),
+					Controls:          (&PVMetrics{}).pvcControls(),
+				},
+				Pod: topology{
+					Nodes:           map[string]node{},
 					MetricTemplates: testMetricTemplate,
 				},
+				StorageClass: topology{
+					Nodes:           map[string]node{},
+					MetricTemplates: testMetricTemplate,
+				},
+				Host: topology{
+					Nodes:          nil,
+					TableTemplates: (&PVMetrics{}).hostTableTemplates(),
+				},
 				Plugins: []pluginSpec{
 					{
 						ID:          "openebs",
 						Label:       "OpenEBS Monitor Plugin",
 						Description: "OpenEBS Monitor Plugin: Monitor OpeneEBS volumes",
-						Interfaces:  []string{"reporter"},
+						Interfaces:  []string{"reporter", "controller"},
 						APIVersion:  "1",
 					},
 				},
@@ -531,18 +662,16 @@ func TestPVMetrics_makeReport(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			got := p.makeReport()
-			if tt.want.PersistentVolume.Nodes == nil {
+			if len(tt.want.PersistentVolume.Nodes) == 0 {
 				if !reflect.DeepEqual(got, tt.want) {
 					t.Errorf("PVMetrics.makeReport() = %v, want %v", got, tt.want)
 				}
 			} else {
+				if len(got.PersistentVolume.Nodes) != len(tt.want.PersistentVolume.Nodes) {
+					t.Errorf("PVMetrics.makeReport() PV nodes = %v, want %v", got.PersistentVolume.Nodes, tt.want.PersistentVolume.Nodes)
+				}
 				for k, v := range got.PersistentVolume.Nodes {
 					for x, y := range v.Metrics {
 						for i, val := range y.Samples {
@@ -578,13 +707,278 @@ func TestPVMetrics_Report(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &PVMetrics{
-				Queries:   tt.fields.Queries,
-				PVList:    tt.fields.PVList,
-				Data:      tt.fields.Data,
-				ClientSet: tt.fields.ClientSet,
-			}
+			p := tt.fields.newPVMetrics()
 			p.Report(tt.args.w, tt.args.r)
 		})
 	}
 }
+
+func TestPVMetrics_metricsWithHistory(t *testing.T) {
+	now := time.Now()
+	history := [][]sample{
+		{
+			{Date: now.Add(-time.Minute), Value: 4.4},
+			{Date: now, Value: 9.6},
+		},
+		nil,
+		{
+			{Date: now.Add(-time.Minute), Value: 3},
+			{Date: now, Value: 1.5},
+		},
+	}
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	got := p.metricsWithHistory([]float64{0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0}, history)
+
+	if want := []float64{4, 10}; !reflect.DeepEqual(sampleValues(got["readIops"].Samples), want) {
+		t.Errorf("readIops samples = %v, want %v", sampleValues(got["readIops"].Samples), want)
+	}
+	if got["readIops"].Min != 4 || got["readIops"].Max != 10 {
+		t.Errorf("readIops min, max = %v, %v, want 4, 10", got["readIops"].Min, got["readIops"].Max)
+	}
+	if want := []float64{2}; !reflect.DeepEqual(sampleValues(got["writeIops"].Samples), want) {
+		t.Errorf("writeIops samples = %v, want %v", sampleValues(got["writeIops"].Samples), want)
+	}
+	if got["readLatency"].Min != 1.5 || got["readLatency"].Max != 3 {
+		t.Errorf("readLatency min, max = %v, %v, want 1.5, 3", got["readLatency"].Min, got["readLatency"].Max)
+	}
+	if history[0][0].Value != 4.4 {
+		t.Errorf("metricsWithHistory() modified the history")
+	}
+}
+
+func sampleValues(samples []sample) []float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.Value
+	}
+	return values
+}
+
+func TestPVMetrics_getPVCTopology(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got, want := p.getPVCTopology("abcdef-1234-pqrst-123"), "abcdef-1234-pqrst-123;<persistent_volume_claim>"; got != want {
+		t.Errorf("PVMetrics.getPVCTopology() = %v, want %v", got, want)
+	}
+}
+
+func TestPVMetrics_makeReportWithPVC(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	p.snapshot.Store(&Snapshot{
+		PVList: map[string]string{
+			"testPV":  "abcdef1234",
+			"testPV1": "abcdef5678",
+		},
+		PVCList: map[string]string{
+			"testPV": "pvc1234",
+		},
+		Data: map[string]map[string]float64{
+			"iopsWriteQuery": map[string]float64{
+				"testPV":  5,
+				"testPV1": 6,
+			},
+		},
+	})
+	got := p.makeReport()
+	if len(got.PersistentVolumeClaim.Nodes) != 1 {
+		t.Fatalf("PVMetrics.makeReport() PVC nodes = %v, want 1 node", got.PersistentVolumeClaim.Nodes)
+	}
+	pvcNode, ok := got.PersistentVolumeClaim.Nodes["pvc1234;<persistent_volume_claim>"]
+	if !ok {
+		t.Fatalf("PVMetrics.makeReport() PVC nodes = %v, want pvc1234;<persistent_volume_claim>", got.PersistentVolumeClaim.Nodes)
+	}
+	if value := pvcNode.Metrics["writeIops"].Samples[0].Value; value != 5 {
+		t.Errorf("PVMetrics.makeReport() PVC writeIops = %v, want 5", value)
+	}
+}
+
+func TestPVMetrics_getPodTopology(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	if got, want := p.getPodTopology("abcdef-1234-pqrst-123"), "abcdef-1234-pqrst-123;<pod>"; got != want {
+		t.Errorf("PVMetrics.getPodTopology() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name    string
+		volumes [][]float64
+		want    []float64
+	}{
+		{
+			name:    "when there are no volumes",
+			volumes: nil,
+			want:    []float64{nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan},
+		},
+		{
+			name: "when volumes miss some metrics",
+			volumes: [][]float64{
+				{10, nan, 2, nan, 100, nan, nan, nan, nan, nan, nan},
+				{nan, nan, 6, nan, 50, 25, nan, nan, nan, nan, nan},
+			},
+			want: []float64{10, nan, 2, nan, 150, 25, nan, nan, nan, nan, nan},
+		},
+		{
+			name: "when volumes have IO",
+			volumes: [][]float64{
+				{10, 0, 2, 4, 100, 0, 100, 50, 50, 10, 20},
+				{30, 0, 6, 8, 50, 25, 300, 30, 10, 30, 40},
+			},
+			want: []float64{40, 0, 5, 6, 150, 25, 400, 80, 20, 40, 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// NaN values are compared through their string representation.
+			if got := aggregate(DefaultCatalog().topology(TopologyVolume), tt.volumes); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("aggregate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPVMetrics_makeReportWithPods(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	p.snapshot.Store(&Snapshot{
+		PVList: map[string]string{
+			"testPV":  "abcdef1234",
+			"testPV1": "abcdef5678",
+		},
+		PodList: map[string][]string{
+			"testPV":  {"pod1"},
+			"testPV1": {"pod1", "pod2"},
+		},
+		Data: map[string]map[string]float64{
+			"iopsWriteQuery": map[string]float64{
+				"testPV":  5,
+				"testPV1": 6,
+			},
+		},
+	})
+	got := p.makeReport()
+	want := map[string]float64{
+		"pod1;<pod>": 11,
+		"pod2;<pod>": 6,
+	}
+	if len(got.Pod.Nodes) != len(want) {
+		t.Fatalf("PVMetrics.makeReport() pod nodes = %v, want %v", got.Pod.Nodes, want)
+	}
+	for podNodeID, writeIops := range want {
+		if value := got.Pod.Nodes[podNodeID].Metrics["writeIops"].Samples[0].Value; value != writeIops {
+			t.Errorf("PVMetrics.makeReport() %s writeIops = %v, want %v", podNodeID, value, writeIops)
+		}
+	}
+}
+
+func TestPVMetrics_makeReportWithStorageClass(t *testing.T) {
+	p := &PVMetrics{
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	p.snapshot.Store(&Snapshot{
+		PVList: map[string]string{
+			"testPV":  "abcdef1234",
+			"testPV1": "abcdef5678",
+			"testPV2": "abcdef9012",
+		},
+		SCList: map[string]string{
+			"testPV":  "sc1",
+			"testPV1": "sc1",
+			"testPV2": "sc2",
+		},
+		Data: map[string]map[string]float64{
+			"iopsWriteQuery": map[string]float64{
+				"testPV":  5,
+				"testPV1": 6,
+				"testPV2": 7,
+			},
+		},
+	})
+	got := p.makeReport()
+	want := map[string]float64{
+		"sc1;<storage_class>": 11,
+		"sc2;<storage_class>": 7,
+	}
+	if len(got.StorageClass.Nodes) != len(want) {
+		t.Fatalf("PVMetrics.makeReport() StorageClass nodes = %v, want %v", got.StorageClass.Nodes, want)
+	}
+	for scNodeID, writeIops := range want {
+		if value := got.StorageClass.Nodes[scNodeID].Metrics["writeIops"].Samples[0].Value; value != writeIops {
+			t.Errorf("PVMetrics.makeReport() %s writeIops = %v, want %v", scNodeID, value, writeIops)
+		}
+	}
+}
+
+func TestPVMetrics_makeReportWithMissingMetrics(t *testing.T) {
+	p := &PVMetrics{}
+	p.snapshot.Store(&Snapshot{
+		PVList: map[string]string{
+			"testPV":  "abcdef1234",
+			"testPV1": "abcdef5678",
+		},
+		Data: map[string]map[string]float64{
+			"iopsReadQuery": {
+				"testPV": 5,
+			},
+			"latencyWriteQuery": {},
+		},
+	})
+	got := p.makeReport()
+	metrics := got.PersistentVolume.Nodes["abcdef1234;<persistent_volume>"].Metrics
+	if len(metrics) != 1 || metrics["readIops"].Samples[0].Value != 5 {
+		t.Errorf("PV metrics = %v, want only readIops", metrics)
+	}
+	if metrics := got.PersistentVolume.Nodes["abcdef5678;<persistent_volume>"].Metrics; len(metrics) != 0 {
+		t.Errorf("PV metrics = %v, want none", metrics)
+	}
+}
+
+func TestPVMetrics_makeReportWithoutData(t *testing.T) {
+	p := &PVMetrics{}
+	p.snapshot.Store(&Snapshot{
+		PVList:  map[string]string{"testPV": "abcdef1234"},
+		PVCList: map[string]string{"testPV": "pvc1234"},
+		Details: map[string]PVDetails{
+			"testPV": {Capacity: "5Gi", Phase: "Bound", Claim: "testPVC"},
+		},
+		CStorVolumes: map[string]CStorVolume{
+			"testPV": {
+				Phase:             "Healthy",
+				ReplicationFactor: 1,
+				Replicas:          []CStorReplica{{Name: "replica-1", Phase: "Healthy"}},
+			},
+		},
+	})
+	got := p.makeReport()
+
+	pvNode, ok := got.PersistentVolume.Nodes["abcdef1234;<persistent_volume>"]
+	if !ok {
+		t.Fatalf("PV nodes = %v, want the PV without data", got.PersistentVolume.Nodes)
+	}
+	if len(pvNode.Metrics) != 0 {
+		t.Errorf("PV metrics = %v, want none", pvNode.Metrics)
+	}
+	for _, key := range []string{"openebs_capacity", "openebs_phase", "openebs_claim", replicaHealth} {
+		if _, ok := pvNode.Latest[key]; !ok {
+			t.Errorf("PV latest = %v, want %s", pvNode.Latest, key)
+		}
+	}
+	if control := pvNode.LatestControls[TakeSnapshotControl]; control.Value.Dead {
+		t.Errorf("PV controls = %v, want the snapshot control of the bound PV", pvNode.LatestControls)
+	}
+	if control := pvNode.LatestControls[ExpandVolumeControl]; !control.Value.Dead {
+		t.Errorf("PV controls = %v, want no expand control without an expandable StorageClass", pvNode.LatestControls)
+	}
+	if _, ok := got.PersistentVolumeClaim.Nodes["pvc1234;<persistent_volume_claim>"]; !ok {
+		t.Errorf("PVC nodes = %v, want the PVC without data", got.PersistentVolumeClaim.Nodes)
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPollInterval is the default interval between two metrics refreshes.
+	DefaultPollInterval = 2 * time.Second
+	// DefaultPollJitter is the default fraction of the interval added at random.
+	DefaultPollJitter = 0.1
+	// DefaultMaxBackoff is the default longest interval after consecutive failures.
+	DefaultMaxBackoff = 2 * time.Minute
+)
+
+// Schedule configures how often UpdateMetrics refreshes the metrics.
+type Schedule struct {
+	// Interval is the delay between two successful refreshes.
+	Interval time.Duration
+	// Jitter is the fraction of the delay added at random, so that plugins
+	// started together do not query the data source at the same time.
+	Jitter float64
+	// MaxBackoff caps the delay, which doubles after each consecutive failure.
+	MaxBackoff time.Duration
+}
+
+// DefaultSchedule returns the default refresh schedule.
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Interval:   DefaultPollInterval,
+		Jitter:     DefaultPollJitter,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Delay returns the delay before the next refresh after the given number of
+// consecutive failures.
+func (s Schedule) Delay(failures int) time.Duration {
+	delay := s.Interval
+	if delay <= 0 {
+		delay = DefaultPollInterval
+	}
+	for i := 0; i < failures && (s.MaxBackoff <= 0 || delay < s.MaxBackoff); i++ {
+		delay = delay * 2
+	}
+	if s.MaxBackoff > 0 && delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	if s.Jitter > 0 {
+		delay = delay + time.Duration(rand.Float64()*s.Jitter*float64(delay))
+	}
+	return delay
+}
+
+// UpdateMetrics will update the metrics data and PV list following the
+// schedule of p until ctx is done.
+func (p *PVMetrics) UpdateMetrics(ctx context.Context) {
+	failures := 0
+	for {
+		if err := p.UpdatePVMetrics(ctx); err != nil {
+			failures++
+			log.Debugf("Failed to update metrics %d times in a row: %v", failures, err)
+		} else {
+			failures = 0
+		}
+
+		timer := time.NewTimer(p.Schedule.Delay(failures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
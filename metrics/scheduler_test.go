@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Delay(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		failures int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "when there is no failure",
+			schedule: Schedule{Interval: time.Second},
+			failures: 0,
+			min:      time.Second,
+			max:      time.Second,
+		},
+		{
+			name:     "when there are consecutive failures",
+			schedule: Schedule{Interval: time.Second, MaxBackoff: time.Minute},
+			failures: 3,
+			min:      8 * time.Second,
+			max:      8 * time.Second,
+		},
+		{
+			name:     "when backoff reaches the maximum",
+			schedule: Schedule{Interval: time.Second, MaxBackoff: 5 * time.Second},
+			failures: 100,
+			min:      5 * time.Second,
+			max:      5 * time.Second,
+		},
+		{
+			name:     "when jitter is set",
+			schedule: Schedule{Interval: time.Second, Jitter: 0.5},
+			failures: 0,
+			min:      time.Second,
+			max:      1500 * time.Millisecond,
+		},
+		{
+			name:     "when interval is not set",
+			schedule: Schedule{},
+			failures: 0,
+			min:      DefaultPollInterval,
+			max:      DefaultPollInterval,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				if got := tt.schedule.Delay(tt.failures); got < tt.min || got > tt.max {
+					t.Errorf("Schedule.Delay() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestPVMetrics_UpdateMetrics(t *testing.T) {
+	p := &PVMetrics{
+		Schedule:  Schedule{Interval: time.Millisecond, MaxBackoff: time.Millisecond},
+		ClientSet: FieldsWithNilValue.ClientSet,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.UpdateMetrics(ctx)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PVMetrics.UpdateMetrics() did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,395 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// DefaultScrapeSelector selects the volume exporter pods, as in the
+	// scrape config of the plugin deployment.
+	DefaultScrapeSelector = "monitoring=volume_exporter_prometheus"
+	// DefaultScrapePort is the port of the volume exporter.
+	DefaultScrapePort = 9500
+	// DefaultScrapeRetention is how long the scrapes are kept by default.
+	DefaultScrapeRetention = 10 * time.Minute
+)
+
+// Scraper is a Source which scrapes the /metrics endpoint of the volume
+// exporter pods directly and evaluates the queries locally, for clusters
+// without a prometheus compatible query API. See parseExpr for the
+// supported queries.
+type Scraper struct {
+	ClientSet  kubernetes.Interface
+	HTTPClient *http.Client
+	// Selector is the label selector of the exporter pods.
+	Selector string
+	Port     int
+	Path     string
+	// Retention is how long the scrapes are kept, which bounds the history
+	// of the range queries and the range of the rates.
+	Retention time.Duration
+	// APIError, if set, is called with the failed Kubernetes API calls.
+	APIError func(resource string, err error)
+
+	// mu guards pods, targets and err.
+	mu sync.Mutex
+	// pods reads the exporter pods from the informer cache, once
+	// StartInformer returned.
+	pods    corelisters.PodLister
+	targets map[string]*target
+	err     error
+}
+
+// scrape holds the values of the metrics of a target at a time, summed
+// over the series of each metric.
+type scrape struct {
+	time   time.Time
+	values map[string]float64
+}
+
+// target is an exporter pod and its scrapes, oldest first.
+type target struct {
+	metric  Metric
+	scrapes []scrape
+}
+
+// NewScraper returns a Scraper of the volume exporter pods.
+func NewScraper(clientSet kubernetes.Interface) *Scraper {
+	return &Scraper{
+		ClientSet: clientSet,
+		Selector:  DefaultScrapeSelector,
+		Port:      DefaultScrapePort,
+		Path:      "/metrics",
+		Retention: DefaultScrapeRetention,
+	}
+}
+
+// Refresh discovers the exporter pods and scrapes them concurrently. A pod
+// which fails keeps its previous scrapes. It returns an error if the pods
+// cannot be listed or all of them failed, which is then returned by the
+// queries until a scrape succeeds. It is part of the Refresher interface.
+func (s *Scraper) Refresh(ctx context.Context) error {
+	pods, err := s.listPods()
+	if err != nil {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		return err
+	}
+
+	values := make([]map[string]float64, len(pods))
+	errs := make([]error, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = s.scrapePod(ctx, pods[i])
+		}(i)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := make(map[string]*target, len(pods))
+	failures := 0
+	for i, pod := range pods {
+		t, ok := s.targets[string(pod.UID)]
+		if !ok {
+			t = &target{metric: podMetric(pod)}
+		}
+		targets[string(pod.UID)] = t
+		if errs[i] != nil {
+			failures++
+			err = fmt.Errorf("failed to scrape %s/%s: %v", pod.Namespace, pod.Name, errs[i])
+			continue
+		}
+		t.scrapes = append(s.recent(t.scrapes, now), scrape{time: now, values: values[i]})
+	}
+	s.targets = targets
+
+	s.err = nil
+	if failures > 0 && failures == len(pods) {
+		s.err = fmt.Errorf("all %d exporters failed, last error: %v", failures, err)
+	}
+	return s.err
+}
+
+// listPods returns the running exporter pods, from the informer cache if
+// StartInformer was called and from the API server otherwise.
+func (s *Scraper) listPods() ([]corev1.Pod, error) {
+	s.mu.Lock()
+	lister := s.pods
+	s.mu.Unlock()
+
+	var podListItems []corev1.Pod
+	if lister != nil {
+		pods, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			podListItems = append(podListItems, *pod)
+		}
+	} else {
+		podList, err := s.ClientSet.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+			LabelSelector: s.Selector,
+		})
+		if err != nil {
+			s.apiError("pods", err)
+			return nil, err
+		}
+		podListItems = podList.Items
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podListItems {
+		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// apiError passes the failed Kubernetes API calls to APIError, if set.
+func (s *Scraper) apiError(resource string, err error) {
+	if err != nil && s.APIError != nil {
+		s.APIError(resource, err)
+	}
+}
+
+// recent returns the scrapes which are within the retention at now.
+func (s *Scraper) recent(scrapes []scrape, now time.Time) []scrape {
+	retention := s.Retention
+	if retention <= 0 {
+		retention = DefaultScrapeRetention
+	}
+	for len(scrapes) > 0 && now.Sub(scrapes[0].time) > retention {
+		scrapes = scrapes[1:]
+	}
+	return scrapes
+}
+
+// scrapePod fetches and parses the metrics of an exporter pod.
+func (s *Scraper) scrapePod(ctx context.Context, pod corev1.Pod) (map[string]float64, error) {
+	url := fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, s.Port, s.Path)
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return parseText(response.Body)
+}
+
+// podMetric returns the labels of the series of an exporter pod, as set by
+// the relabeling of the deployment scrape config.
+func podMetric(pod corev1.Pod) Metric {
+	labels := pod.GetLabels()
+	return Metric{
+		"kubernetes_pod_name": pod.Name,
+		"openebs_pv":          labels["openebs.io/persistent-volume"],
+		"openebs_pvc":         labels["openebs.io/persistent-volume-claim"],
+		"cstor_pool":          labels["openebs.io/cstor-pool"],
+	}
+}
+
+// parseText parses the prometheus text exposition format and returns the
+// value of each metric, summed over its series.
+func parseText(r io.Reader) (map[string]float64, error) {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, rest, err := splitSeries(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		// The value may be followed by a timestamp.
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: invalid sample %q", line, text)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		values[name] += value
+	}
+	return values, scanner.Err()
+}
+
+// splitSeries splits a sample line into the metric name and what follows
+// the labels, if any.
+func splitSeries(text string) (string, string, error) {
+	end := strings.IndexAny(text, "{ \t")
+	if end <= 0 {
+		return "", "", fmt.Errorf("invalid sample %q", text)
+	}
+	name := text[:end]
+	if text[end] != '{' {
+		return name, text[end:], nil
+	}
+
+	quoted := false
+	for i := end + 1; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '}' && !quoted:
+			return name, text[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated labels in %q", text)
+}
+
+// Query evaluates query on the last scrape of each exporter pod. It is part
+// of the Source interface.
+func (s *Scraper) Query(ctx context.Context, query string) (*Metrics, error) {
+	e, err := parseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var result []Result
+	for _, t := range s.targets {
+		last := len(t.scrapes) - 1
+		if last < 0 {
+			continue
+		}
+		value, ok := e.eval(t.scrapes, last)
+		if !ok {
+			continue
+		}
+		result = append(result, Result{
+			Metric: t.metric,
+			Value:  []interface{}{timestamp(t.scrapes[last].time), formatValue(value)},
+		})
+	}
+	return queryResponse("vector", result)
+}
+
+// QueryRange evaluates query on the scrapes of each exporter pod between
+// start and end, at most one sample per step. It is part of the Source
+// interface.
+func (s *Scraper) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error) {
+	e, err := parseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var result []Result
+	for _, t := range s.targets {
+		var values [][]interface{}
+		var previous time.Time
+		for i, sc := range t.scrapes {
+			if sc.time.Before(start) || sc.time.After(end) || (!previous.IsZero() && sc.time.Sub(previous) < step) {
+				continue
+			}
+			value, ok := e.eval(t.scrapes, i)
+			if !ok {
+				continue
+			}
+			values = append(values, []interface{}{timestamp(sc.time), formatValue(value)})
+			previous = sc.time
+		}
+		if len(values) > 0 {
+			result = append(result, Result{
+				Metric: t.metric,
+				Values: values,
+			})
+		}
+	}
+	return queryResponse("matrix", result)
+}
+
+// Check returns the error of the last refresh. It is part of the Source
+// interface.
+func (s *Scraper) Check(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Scraper) String() string {
+	return fmt.Sprintf("exporter pods selected by %q on port %d", s.Selector, s.Port)
+}
+
+// queryResponse wraps the result of a query evaluated locally, which fails
+// with ErrEmptyResult if no series matched.
+func queryResponse(resultType string, result []Result) (*Metrics, error) {
+	if len(result) == 0 {
+		return nil, ErrEmptyResult
+	}
+	return &Metrics{
+		Status: "success",
+		Data: Data{
+			ResultType: resultType,
+			Result:     result,
+		},
+	}, nil
+}
+
+// timestamp returns t in seconds, as in the prometheus query API.
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// formatValue formats a sample value as in the prometheus query API.
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
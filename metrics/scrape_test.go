@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseText(t *testing.T) {
+	text := `# HELP openebs_reads Read Input/Outputs on Volume
+# TYPE openebs_reads counter
+openebs_reads 120
+openebs_read_time{volume="pvc-1",path="a}b \"c\""} 2.5e+06 1514764800000
+openebs_read_time{volume="pvc-1",replica="2"} 500000
+
+openebs_size_of_volume 5
+`
+	got, err := parseText(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("parseText() error = %v", err)
+	}
+	want := map[string]float64{
+		"openebs_reads":          120,
+		"openebs_read_time":      3e6,
+		"openebs_size_of_volume": 5,
+	}
+	if len(got) != len(want) {
+		t.Errorf("parseText() = %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("parseText() %s = %v, want %v", name, got[name], value)
+		}
+	}
+
+	for _, text := range []string{"openebs_reads", "openebs_reads{volume=\"a\" 1", "openebs_reads one"} {
+		if _, err := parseText(strings.NewReader(text)); err == nil {
+			t.Errorf("parseText(%q) error = nil, want an error", text)
+		}
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	now := time.Now()
+	scrapes := []scrape{
+		{time: now.Add(-20 * time.Second), values: map[string]float64{"openebs_reads": 0, "openebs_read_time": 0}},
+		{time: now.Add(-10 * time.Second), values: map[string]float64{"openebs_reads": 100, "openebs_read_time": 2e6}},
+		{time: now, values: map[string]float64{"openebs_reads": 300, "openebs_read_time": 4e6, "openebs_read_block_count": 4096}},
+	}
+	tests := []struct {
+		query   string
+		want    float64
+		wantOk  bool
+		wantErr bool
+	}{
+		{query: "irate(openebs_reads[5m])", want: 20, wantOk: true},
+		{query: "rate(openebs_reads[5m])", want: 15, wantOk: true},
+		{query: "rate(openebs_reads[15s])", want: 20, wantOk: true},
+		{query: "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000", want: 0.01, wantOk: true},
+		{query: "openebs_read_block_count/(2048) - -1", want: 3, wantOk: true},
+		{query: "openebs_read_time * 1e-6", want: 4, wantOk: true},
+		{query: "openebs_read_block_count / 1.024E+3", want: 4, wantOk: true},
+		{query: "openebs_reads * 1e", wantErr: true},
+		{query: "irate(openebs_read_block_count[5m])", wantOk: false},
+		{query: "openebs_writes * 2", wantOk: false},
+		{query: "(sum by (cstor_pool) (irate(openebs_read_time[5m])))/(sum by (cstor_pool, vol) (irate(openebs_reads[5m])))", want: 1e4, wantOk: true},
+		{query: "sum(openebs_reads)", wantErr: true},
+		{query: "sum by (openebs_reads)", wantErr: true},
+		{query: "openebs_reads{openebs_pv=\"a\"}", wantErr: true},
+		{query: "irate(openebs_reads[5m]", wantErr: true},
+		{query: "openebs_reads openebs_writes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e, err := parseExpr(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExpr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, ok := e.eval(scrapes, len(scrapes)-1)
+			if ok != tt.wantOk || (ok && math.Abs(got-tt.want) > 1e-9) {
+				t.Errorf("eval() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestScraper_Refresh(t *testing.T) {
+	reads := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		reads += 100
+		w.Write([]byte("openebs_reads " + strconv.Itoa(reads) + "\n"))
+	}))
+	defer testServer.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(testServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testPV-ctrl",
+			Namespace: "openebs",
+			UID:       "pod1234",
+			Labels: map[string]string{
+				"monitoring":                   "volume_exporter_prometheus",
+				"openebs.io/persistent-volume": "testPV",
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: host,
+		},
+	}
+	otherPod := pod.DeepCopy()
+	otherPod.Name, otherPod.UID, otherPod.Labels = "app", "pod5678", nil
+
+	clientSet := fake.NewSimpleClientset(pod, otherPod)
+	scraper := NewScraper(clientSet)
+	scraper.Port, _ = strconv.Atoi(port)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := scraper.StartInformer(stopCh); err != nil {
+		t.Fatalf("Scraper.StartInformer() error = %v", err)
+	}
+	// The PV lists of p are polled, so only the lists of the exporter pods
+	// are counted.
+	listPods := func() int {
+		count := 0
+		for _, action := range clientSet.Actions() {
+			if list, ok := action.(k8stesting.ListAction); ok && action.Matches("list", "pods") && !list.GetListRestrictions().Labels.Empty() {
+				count++
+			}
+		}
+		return count
+	}
+	lists := listPods()
+	p := &PVMetrics{
+		ClientSet: clientSet,
+		Source:    scraper,
+		Queries:   map[string]string{"iopsReadQuery": "irate(openebs_reads[5m])"},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.UpdatePVMetrics(context.Background()); err != nil {
+			t.Fatalf("UpdatePVMetrics() error = %v", err)
+		}
+	}
+	got := p.Snapshot().Data["iopsReadQuery"]
+	if len(got) != 1 || got["testPV"] <= 0 {
+		t.Errorf("Data[iopsReadQuery] = %v, want a positive rate for testPV only", got)
+	}
+	if got := listPods(); got != lists {
+		t.Errorf("pods listed %d times, want the pods read from the informer cache", got-lists)
+	}
+
+	p.Range = RangeQuery{Window: time.Minute, Step: time.Nanosecond}
+	samples, err := p.GetRangeMetrics(context.Background(), "openebs_reads")
+	if err != nil {
+		t.Fatalf("GetRangeMetrics() error = %v", err)
+	}
+	if values := sampleValues(samples["testPV"]); len(values) != 2 || values[1] != 200 {
+		t.Errorf("GetRangeMetrics() = %v, want the 2 scraped values", values)
+	}
+
+	testServer.Close()
+	if err := scraper.Refresh(context.Background()); err == nil {
+		t.Fatal("Scrape() error = nil, want an error when all exporters fail")
+	}
+	if _, err := p.GetMetrics(context.Background(), "openebs_reads"); err == nil {
+		t.Error("GetMetrics() error = nil, want the scrape error")
+	}
+}
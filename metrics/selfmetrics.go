@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// selfMetricsPrefix is the prefix of the metrics of the plugin itself.
+const selfMetricsPrefix = "openebs_plugin_"
+
+// selfMetrics records the activity of the plugin, exposed by ServeMetrics.
+type selfMetrics struct {
+	mu sync.Mutex
+	// queries holds the latency and errors of each query, by query name.
+	queries map[string]*queryStats
+	// reports and reportSeconds are the number and the total duration of
+	// the generated reports, and reportBytes is the size of the last one.
+	reports       float64
+	reportSeconds float64
+	reportBytes   float64
+	// apiErrors is the number of failed Kubernetes API calls by resource.
+	apiErrors map[string]float64
+}
+
+type queryStats struct {
+	count   float64
+	seconds float64
+	errors  float64
+}
+
+// observeQueries records the latency and the outcome of the query results.
+func (m *selfMetrics) observeQueries(results []queryResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queries == nil {
+		m.queries = make(map[string]*queryStats)
+	}
+	for _, result := range results {
+		stats := m.queries[result.name]
+		if stats == nil {
+			stats = &queryStats{}
+			m.queries[result.name] = stats
+		}
+		stats.count++
+		stats.seconds += result.latency.Seconds()
+		if result.err != nil {
+			stats.errors++
+		}
+	}
+}
+
+// observeReport records the duration and the size of a report.
+func (m *selfMetrics) observeReport(duration time.Duration, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports++
+	m.reportSeconds += duration.Seconds()
+	m.reportBytes = float64(size)
+}
+
+// apiError counts a failed Kubernetes API call on resource. Missing
+// resources, such as the cStor custom resources of clusters without cStor,
+// are not counted.
+func (m *selfMetrics) apiError(resource string, err error) {
+	if err == nil || apierrors.IsNotFound(err) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.apiErrors == nil {
+		m.apiErrors = make(map[string]float64)
+	}
+	m.apiErrors[resource]++
+}
+
+// APIError counts a failed Kubernetes API call on resource made outside of
+// p, e.g. by the ServiceDiscovery or the Scraper of its source.
+func (p *PVMetrics) APIError(resource string, err error) {
+	p.stats.apiError(resource, err)
+}
+
+// ServeMetrics serves the metrics of the plugin itself in the prometheus
+// text exposition format.
+func (p *PVMetrics) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(p.selfMetricsText())
+}
+
+// selfMetricsText returns the metrics of the plugin in the prometheus text
+// exposition format.
+func (p *PVMetrics) selfMetricsText() []byte {
+	s := p.Snapshot()
+	m := &p.stats
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	family := func(name, kind, help string, series map[string]float64) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n", selfMetricsPrefix, name, help)
+		fmt.Fprintf(&buf, "# TYPE %s%s %s\n", selfMetricsPrefix, name, kind)
+		labels := make([]string, 0, len(series))
+		for label := range series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(&buf, "%s%s%s %s\n", selfMetricsPrefix, name, label, strconv.FormatFloat(series[label], 'g', -1, 64))
+		}
+	}
+	byLabel := func(suffix, label string, values map[string]float64, series map[string]float64) map[string]float64 {
+		if series == nil {
+			series = make(map[string]float64, len(values))
+		}
+		for value, v := range values {
+			series[fmt.Sprintf("%s{%s=%q}", suffix, label, value)] = v
+		}
+		return series
+	}
+
+	count := make(map[string]float64)
+	seconds := make(map[string]float64)
+	errs := make(map[string]float64)
+	for name, stats := range m.queries {
+		count[name] = stats.count
+		seconds[name] = stats.seconds
+		errs[name] = stats.errors
+	}
+	family("query_duration_seconds", "summary", "Duration of the queries to the data source.",
+		byLabel("_count", "query", count, byLabel("_sum", "query", seconds, nil)))
+	family("query_errors_total", "counter", "Number of failed queries to the data source.", byLabel("", "query", errs, nil))
+
+	var refreshed float64
+	if !s.Time.IsZero() {
+		refreshed = float64(s.Time.UnixNano()) / 1e9
+	}
+	family("last_refresh_timestamp_seconds", "gauge", "Time of the last successful refresh of the metrics.", map[string]float64{"": refreshed})
+	family("persistent_volumes", "gauge", "Number of PVs reported.", map[string]float64{"": float64(len(s.PVList))})
+	family("report_duration_seconds", "summary", "Duration of the report generation.", map[string]float64{"_sum": m.reportSeconds, "_count": m.reports})
+	family("report_size_bytes", "gauge", "Size of the last report.", map[string]float64{"": m.reportBytes})
+	family("kubernetes_api_errors_total", "counter", "Number of failed Kubernetes API calls.", byLabel("", "resource", m.apiErrors, nil))
+	return buf.Bytes()
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestPVMetrics_ServeMetrics(t *testing.T) {
+	p := &PVMetrics{}
+	refreshed := time.Unix(1500000000, 0)
+	p.update(func(s *Snapshot) {
+		s.PVList = map[string]string{"pvc-1": "uid-1", "pvc-2": "uid-2"}
+		s.Time = refreshed
+	})
+	p.stats.observeQueries([]queryResult{
+		{name: "readIOPS", latency: 2 * time.Second},
+		{name: "writeIOPS", latency: time.Second, err: errors.New("timeout")},
+	})
+	p.stats.observeQueries([]queryResult{
+		{name: "readIOPS", latency: time.Second, err: errors.New("timeout")},
+	})
+	p.stats.apiError("pods", errors.New("connection refused"))
+	p.stats.apiError("cstorvolumes", apierrors.NewNotFound(schema.GroupResource{Resource: "cstorvolumes"}, ""))
+	p.Report(httptest.NewRecorder(), httptest.NewRequest("GET", "/report", nil))
+
+	w := httptest.NewRecorder()
+	p.ServeMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4" {
+		t.Errorf("ServeMetrics() content type = %q", got)
+	}
+	got, err := parseText(w.Body)
+	if err != nil {
+		t.Fatalf("ServeMetrics() served invalid metrics: %v", err)
+	}
+
+	want := map[string]float64{
+		"openebs_plugin_query_duration_seconds_sum":     4,
+		"openebs_plugin_query_duration_seconds_count":   3,
+		"openebs_plugin_query_errors_total":             2,
+		"openebs_plugin_last_refresh_timestamp_seconds": 1500000000,
+		"openebs_plugin_persistent_volumes":             2,
+		"openebs_plugin_report_duration_seconds_count":  1,
+		"openebs_plugin_kubernetes_api_errors_total":    1,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("ServeMetrics() %s = %v, want %v", name, got[name], value)
+		}
+	}
+	if got["openebs_plugin_report_size_bytes"] <= 0 {
+		t.Errorf("ServeMetrics() report size = %v, want the size of the report", got["openebs_plugin_report_size_bytes"])
+	}
+}
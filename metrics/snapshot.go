@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Snapshot is an immutable view of the PVs and of their metrics. Once
+// published, neither the snapshot nor its maps are modified: updates publish
+// a new snapshot instead, so the reporter can read it without locking.
+type Snapshot struct {
+	PVList       map[string]string
+	PVCList      map[string]string
+	PodList      map[string][]string
+	SCList       map[string]string
+	Expandable   map[string]bool
+	Details      map[string]PVDetails
+	CStorVolumes map[string]CStorVolume
+	CStorPools   map[string]CStorPool
+	Data         map[string]map[string]float64
+	History      map[string]map[string][]sample
+	Status       map[string]QueryStatus
+	// Alerts holds the state of the alert rules, keyed by PV name and rule name.
+	Alerts map[string]map[string]AlertState
+	// Time is the time of the last refresh of the metrics.
+	Time time.Time
+}
+
+// Snapshot returns the current snapshot of p, which must not be modified.
+func (p *PVMetrics) Snapshot() *Snapshot {
+	if s, ok := p.snapshot.Load().(*Snapshot); ok {
+		return s
+	}
+	return &Snapshot{}
+}
+
+// update publishes a copy of the current snapshot modified by f. Updates are
+// serialized, and f must replace the maps it changes instead of modifying them.
+func (p *PVMetrics) update(f func(s *Snapshot)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := *p.Snapshot()
+	f(&s)
+	p.snapshot.Store(&s)
+}
+
+// merge replaces the data of the successful queries, keeping the previous
+// data of the failed ones. The history is only kept if withHistory is true.
+func (s *Snapshot) merge(queries map[string]string, results []queryResult, withHistory bool) {
+	data := make(map[string]map[string]float64, len(queries))
+	history := make(map[string]map[string][]sample, len(queries))
+	for queryName := range queries {
+		if values, ok := s.Data[queryName]; ok {
+			data[queryName] = values
+		}
+		if samples, ok := s.History[queryName]; ok {
+			history[queryName] = samples
+		}
+	}
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		data[result.name] = result.values
+		if result.samples != nil {
+			history[result.name] = result.samples
+		}
+	}
+
+	s.Data = data
+	if withHistory {
+		s.History = history
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPVMetrics_SnapshotConcurrently(t *testing.T) {
+	respHavingProperResult := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"openebs_pv":"testPV"},"value":[1528354477.902, "5"]}]}}`
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(respHavingProperResult))
+	}))
+	defer testServer.Close()
+
+	clientSet := fake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "testPV",
+			UID:  "pv1234",
+		},
+	})
+	p := NewMetrics(DefaultCatalog(), clientSet)
+	p.Range.Window = 0
+	p.Source = NewPrometheusSource(testServer.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				p.UpdatePVMetrics(context.Background())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p.Report(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report", nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := p.Snapshot()
+	if got := s.PVList["testPV"]; got != "pv1234" {
+		t.Errorf("Snapshot().PVList[testPV] = %q, want pv1234", got)
+	}
+	if got := s.Data["iopsReadQuery"]["testPV"]; got != 5 {
+		t.Errorf("Snapshot().Data[iopsReadQuery][testPV] = %v, want 5", got)
+	}
+	if s.Time.IsZero() {
+		t.Errorf("Snapshot().Time is zero, want the time of the last refresh")
+	}
+	if got := p.makeReport().PersistentVolume.Nodes["pv1234;<persistent_volume>"].Metrics["readIops"].Samples[0].Value; got != 5 {
+		t.Errorf("PVMetrics.makeReport() readIops = %v, want 5", got)
+	}
+}
@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// DefaultSourceURL is the address of the prometheus sidecar of the plugin
+// deployment, which is the default data source.
+const DefaultSourceURL = "http://localhost:80"
+
+// Source is a data source of the metrics, queried with PromQL. The results
+// are in the format of the prometheus query API, and a query which matches
+// no series fails with ErrEmptyResult.
+type Source interface {
+	// Query runs an instant query.
+	Query(ctx context.Context, query string) (*Metrics, error)
+	// QueryRange runs a range query from start to end with the given step.
+	QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error)
+	// Check returns an error if the source is not healthy.
+	Check(ctx context.Context) error
+}
+
+// Refresher is implemented by the sources which must be refreshed before
+// each round of queries, such as the Scraper.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// PrometheusSource queries the HTTP API of prometheus.
+type PrometheusSource struct {
+	// URL is the base URL of the API, e.g. http://localhost:9090.
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewPrometheusSource returns a PrometheusSource of the API at baseURL.
+func NewPrometheusSource(baseURL string) *PrometheusSource {
+	return &PrometheusSource{
+		URL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Query is part of the Source interface.
+func (s *PrometheusSource) Query(ctx context.Context, query string) (*Metrics, error) {
+	return s.query(ctx, "/api/v1/query", url.Values{
+		"query": {query},
+	})
+}
+
+// QueryRange is part of the Source interface.
+func (s *PrometheusSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error) {
+	return s.query(ctx, "/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {strconv.FormatInt(start.Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Unix(), 10)},
+		"step":  {strconv.FormatInt(int64(step.Seconds()), 10)},
+	})
+}
+
+// Check is part of the Source interface. It fetches the build information
+// of prometheus, or runs a trivial query on the compatible APIs which do not
+// serve it.
+func (s *PrometheusSource) Check(ctx context.Context) error {
+	_, err := s.get(ctx, "/api/v1/status/buildinfo", nil)
+	if err == nil {
+		return nil
+	}
+	if _, queryErr := s.Query(ctx, "vector(1)"); queryErr == nil {
+		return nil
+	}
+	return err
+}
+
+func (s *PrometheusSource) String() string {
+	return "prometheus at " + s.URL
+}
+
+// query runs a query on the API at path and returns the non-empty result.
+func (s *PrometheusSource) query(ctx context.Context, path string, params url.Values) (*Metrics, error) {
+	body, err := s.get(ctx, path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := new(Metrics)
+	if err := json.Unmarshal(body, metrics); err != nil {
+		return nil, err
+	}
+	if len(metrics.Data.Result) == 0 {
+		return nil, ErrEmptyResult
+	}
+	return metrics, nil
+}
+
+// get sends a GET request to the API at path and returns the response body.
+func (s *PrometheusSource) get(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	requestURL := s.URL + path
+	if len(params) > 0 {
+		requestURL += "?" + params.Encode()
+	}
+	request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s: %s", path, response.Status, apiError(body))
+	}
+	return body, nil
+}
+
+// apiError returns the error message of a failed API response.
+func apiError(body []byte) string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Error != "" {
+		return response.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// CortexSource queries cortex, or the cortex agent, through its prometheus
+// compatible API. Cortex does not serve the status endpoints of prometheus,
+// so it is checked with a trivial query instead.
+type CortexSource struct {
+	PrometheusSource
+}
+
+// NewCortexSource returns a CortexSource of the API at baseURL.
+func NewCortexSource(baseURL string) *CortexSource {
+	return &CortexSource{
+		PrometheusSource: *NewPrometheusSource(baseURL),
+	}
+}
+
+// Check is part of the Source interface.
+func (s *CortexSource) Check(ctx context.Context) error {
+	_, err := s.Query(ctx, "vector(1)")
+	return err
+}
+
+func (s *CortexSource) String() string {
+	return "cortex at " + s.URL
+}
+
+// FixtureSource serves static results read from a file, to run the plugin
+// without a metrics backend. The file maps each query to its series, e.g.
+//
+//	irate(openebs_reads[5m]):
+//	- metric: {openebs_pv: pvc-1}
+//	  value: 12
+//
+// Queries missing from the file have an empty result.
+type FixtureSource struct {
+	Path   string
+	Series map[string][]FixtureSeries
+}
+
+// FixtureSeries is a series of a FixtureSource, with a constant value.
+type FixtureSeries struct {
+	Metric Metric  `json:"metric"`
+	Value  float64 `json:"value"`
+}
+
+// LoadFixture reads a YAML or JSON fixture from the given file.
+func LoadFixture(path string) (*FixtureSource, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FixtureSource{
+		Path: path,
+	}
+	if err := yaml.Unmarshal(raw, &s.Series); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %q: %v", path, err)
+	}
+	return s, nil
+}
+
+// Query is part of the Source interface.
+func (s *FixtureSource) Query(ctx context.Context, query string) (*Metrics, error) {
+	now := timestamp(time.Now())
+	var result []Result
+	for _, series := range s.Series[query] {
+		result = append(result, Result{
+			Metric: series.Metric,
+			Value:  []interface{}{now, formatValue(series.Value)},
+		})
+	}
+	return queryResponse("vector", result)
+}
+
+// QueryRange is part of the Source interface. The series are constant over
+// the range.
+func (s *FixtureSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*Metrics, error) {
+	if step <= 0 {
+		step = DefaultRangeStep
+	}
+	var result []Result
+	for _, series := range s.Series[query] {
+		var values [][]interface{}
+		for t := start; !t.After(end); t = t.Add(step) {
+			values = append(values, []interface{}{timestamp(t), formatValue(series.Value)})
+		}
+		result = append(result, Result{
+			Metric: series.Metric,
+			Values: values,
+		})
+	}
+	return queryResponse("matrix", result)
+}
+
+// Check is part of the Source interface. A fixture is always healthy.
+func (s *FixtureSource) Check(ctx context.Context) error {
+	return nil
+}
+
+func (s *FixtureSource) String() string {
+	return "fixture " + s.Path
+}
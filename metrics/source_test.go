@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrometheusSource(t *testing.T) {
+	respHavingProperResult := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"openebs_pv":"testPV"},"value":[1528354477.902, "5"]}]}}`
+	var gotPath, gotQuery string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.Query().Get("query")
+		switch r.URL.Path {
+		case "/api/v1/query", "/api/v1/query_range":
+			if r.URL.Query().Get("query") == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+				return
+			}
+			w.Write([]byte(respHavingProperResult))
+		case "/api/v1/status/buildinfo":
+			w.Write([]byte(`{"status":"success","data":{"version":"2.13.1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer testServer.Close()
+
+	s := NewPrometheusSource(testServer.URL + "/")
+	query := "((irate(openebs_read_time[5m]))/(irate(openebs_reads[5m])))/1000000"
+	got, err := s.Query(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if gotPath != "/api/v1/query" || gotQuery != query {
+		t.Errorf("Query() sent %s?query=%s, want /api/v1/query?query=%s", gotPath, gotQuery, query)
+	}
+	if len(got.Data.Result) != 1 || got.Data.Result[0].Metric["openebs_pv"] != "testPV" {
+		t.Errorf("Query() = %+v, want the testPV series", got)
+	}
+
+	if _, err := s.QueryRange(context.Background(), query, time.Now().Add(-time.Minute), time.Now(), 15*time.Second); err != nil || gotPath != "/api/v1/query_range" {
+		t.Errorf("QueryRange() sent %s, error = %v", gotPath, err)
+	}
+	if _, err := s.Query(context.Background(), "bad"); err == nil || err.Error() != "/api/v1/query returned 400 Bad Request: parse error" {
+		t.Errorf("Query() error = %v, want the API error", err)
+	}
+	if err := s.Check(context.Background()); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	if err := NewCortexSource(testServer.URL).Check(context.Background()); err != nil || gotQuery != "vector(1)" {
+		t.Errorf("CortexSource.Check() sent %q, error = %v", gotQuery, err)
+	}
+
+	testServer.Close()
+	if err := s.Check(context.Background()); err == nil {
+		t.Error("Check() error = nil, want an error when prometheus is down")
+	}
+}
+
+func TestFixtureSource(t *testing.T) {
+	path := writeCatalog(t, "fixture.yaml", `
+irate(openebs_reads[5m]):
+- metric: {openebs_pv: testPV}
+  value: 12
+- metric: {cstor_pool: pool-a}
+  value: 3
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("LoadFixture() error = %v", err)
+	}
+	p := &PVMetrics{
+		Source: s,
+	}
+	got, err := p.GetMetrics(context.Background(), "irate(openebs_reads[5m])")
+	if err != nil {
+		t.Fatalf("GetMetrics() error = %v", err)
+	}
+	if want := map[string]float64{"testPV": 12, "pool-a": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetMetrics() = %v, want %v", got, want)
+	}
+	if _, err := p.GetMetrics(context.Background(), "irate(openebs_writes[5m])"); err != ErrEmptyResult {
+		t.Errorf("GetMetrics() error = %v, want ErrEmptyResult for a query missing from the fixture", err)
+	}
+
+	p.Range = RangeQuery{Window: time.Minute, Step: 15 * time.Second}
+	samples, err := p.GetRangeMetrics(context.Background(), "irate(openebs_reads[5m])")
+	if err != nil {
+		t.Fatalf("GetRangeMetrics() error = %v", err)
+	}
+	if values := sampleValues(samples["testPV"]); len(values) != 5 || values[4] != 12 {
+		t.Errorf("GetRangeMetrics() = %v, want 5 samples of 12", values)
+	}
+}
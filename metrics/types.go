@@ -1,8 +1,11 @@
 package metrics
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -24,13 +27,68 @@ type metricTemplate struct {
 	Priority float64 `json:"priority,omitempty"`
 }
 
+type metadataTemplate struct {
+	ID       string  `json:"id"`
+	Label    string  `json:"label,omitempty"`
+	Priority float64 `json:"priority,omitempty"`
+	From     string  `json:"from,omitempty"`
+}
+
+const (
+	// propertyListType is the type of the tables listing label and value rows.
+	propertyListType = "property-list"
+	// multiColumnTableType is the type of the tables with several columns,
+	// whose latest keys are <prefix><row>___<column>.
+	multiColumnTableType = "multicolumn-table"
+)
+
+type column struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	DataType string `json:"dataType,omitempty"`
+}
+
+type tableTemplate struct {
+	ID      string   `json:"id"`
+	Label   string   `json:"label"`
+	Prefix  string   `json:"prefix"`
+	Type    string   `json:"type,omitempty"`
+	Columns []column `json:"columns,omitempty"`
+}
+
+type latestEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     string    `json:"value"`
+}
+
+type control struct {
+	ID    string `json:"id"`
+	Human string `json:"human"`
+	Icon  string `json:"icon"`
+	Rank  int    `json:"rank"`
+}
+
+type controlData struct {
+	Dead bool `json:"dead"`
+}
+
+type controlEntry struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Value     controlData `json:"value"`
+}
+
 type node struct {
-	Metrics map[string]metric `json:"metrics"`
+	Metrics        map[string]metric       `json:"metrics"`
+	Latest         map[string]latestEntry  `json:"latest,omitempty"`
+	LatestControls map[string]controlEntry `json:"latestControls,omitempty"`
 }
 
 type topology struct {
-	Nodes           map[string]node           `json:"nodes"`
-	MetricTemplates map[string]metricTemplate `json:"metric_templates"`
+	Nodes             map[string]node             `json:"nodes"`
+	MetricTemplates   map[string]metricTemplate   `json:"metric_templates"`
+	MetadataTemplates map[string]metadataTemplate `json:"metadata_templates,omitempty"`
+	TableTemplates    map[string]tableTemplate    `json:"table_templates,omitempty"`
+	Controls          map[string]control          `json:"controls,omitempty"`
 }
 
 type pluginSpec struct {
@@ -42,38 +100,94 @@ type pluginSpec struct {
 }
 
 type request struct {
-	NodeID string
+	NodeID  string
+	Control string
 }
 
 type report struct {
-	PersistentVolume topology
-	Plugins          []pluginSpec
+	PersistentVolume      topology
+	PersistentVolumeClaim topology
+	Pod                   topology
+	StorageClass          topology
+	Host                  topology
+	Plugins               []pluginSpec
 }
 
 type response struct {
 	ShortcutReport *report `json:"shortcutReport,omitempty"`
+	Error          string  `json:"error,omitempty"`
 }
 
-// PVMetrics will store all the queries and data.
-type PVMetrics struct {
-	Queries   map[string]string
-	PVList    map[string]string
-	Data      map[string]map[string]float64
-	ClientSet kubernetes.Interface
+// RangeQuery holds the window and step used for prometheus range queries.
+// A zero Window makes PVMetrics fall back to instant queries.
+type RangeQuery struct {
+	Window time.Duration
+	Step   time.Duration
 }
 
-type Metric struct {
-	Name              string `json:"__name__"`
-	Instance          string `json:"instance"`
-	Job               string `json:"job"`
-	KubernetesPodName string `json:"kubernetes_pod_name"`
-	OpenebsPv         string `json:"openebs_pv"`
-	OpenebsPvc        string `json:"openebs_pvc"`
+// QueryStatus records the outcome of the last run of a query.
+type QueryStatus struct {
+	// Updated is the time the query last succeeded, zero if it never did.
+	Updated time.Time
+	// Checked is the time the query was last run.
+	Checked time.Time
+	// Err is the error of the last run, nil if it succeeded.
+	Err error
 }
 
+// Stale reports whether the last run of the query failed, in which case its
+// data, if any, comes from an earlier run.
+func (s QueryStatus) Stale() bool {
+	return s.Err != nil
+}
+
+// PVMetrics will store all the queries and the current snapshot of data.
+// It must not be copied after first use.
+type PVMetrics struct {
+	PluginID     string
+	Schedule     Schedule
+	QueryTimeout time.Duration
+	Workers      int
+	PodNamespace string
+	Catalog      Catalog
+	Queries      map[string]string
+	Range        RangeQuery
+	// Source is the data source of the metrics, the prometheus sidecar of
+	// the plugin deployment by default.
+	Source Source
+	// Identity matches the series of the results to the PVs and cStor
+	// pools, DefaultIdentityRules if nil.
+	Identity IdentityRules
+	// AlertRules are evaluated after each refresh, and the alerts which fire
+	// or are resolved are sent to Webhook if set.
+	AlertRules AlertRules
+	Webhook    *Webhook
+	ClientSet  kubernetes.Interface
+	// DynamicClient reads and creates the custom resources, such as volume
+	// snapshots. Controls which need it fail when it is nil.
+	DynamicClient dynamic.Interface
+	// ExpandSize is the size in GiB added to a PVC by the expand control.
+	ExpandSize int64
+	listers    *listers
+
+	// snapshot holds the current *Snapshot, mu serializes its updates.
+	snapshot atomic.Value
+	mu       sync.Mutex
+	// errorLogs is the number of query errors logged since the last
+	// successful refresh, guarded by mu.
+	errorLogs int
+	// stats is exposed by ServeMetrics.
+	stats selfMetrics
+}
+
+// Metric holds the labels of a series, which are matched to the PVs and
+// cStor pools by the IdentityRules.
+type Metric map[string]string
+
 type Result struct {
-	Metric Metric        `json:"metric"`
-	Value  []interface{} `json:"value"`
+	Metric Metric          `json:"metric"`
+	Value  []interface{}   `json:"value"`
+	Values [][]interface{} `json:"values,omitempty"`
 }
 
 type Data struct {